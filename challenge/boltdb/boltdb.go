@@ -0,0 +1,147 @@
+// Package boltdb implements a challenge.Store backed by a BoltDB file, so
+// issued tokens survive a server restart.
+package boltdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/micromdm/scep/v2/challenge"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketName = []byte("scep_challenges")
+
+// Store is a BoltDB-backed challenge.Store.
+type Store struct {
+	db *bolt.DB
+}
+
+// New opens (creating if necessary) a BoltDB database at path and
+// returns a Store backed by it.
+func New(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("challenge/boltdb: opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("challenge/boltdb: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close releases the underlying BoltDB file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put implements challenge.Store.
+func (s *Store) Put(t *challenge.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(t.ID), data)
+	})
+}
+
+// Get implements challenge.Store.
+func (s *Store) Get(id string) (*challenge.Token, error) {
+	var t *challenge.Token
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketName).Get([]byte(id))
+		if data == nil {
+			return challenge.ErrNotFound
+		}
+		t = &challenge.Token{}
+		return json.Unmarshal(data, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Delete implements challenge.Store.
+func (s *Store) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(id))
+	})
+}
+
+// List implements challenge.Store.
+func (s *Store) List() ([]*challenge.Token, error) {
+	var out []*challenge.Token
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, data []byte) error {
+			t := &challenge.Token{}
+			if err := json.Unmarshal(data, t); err != nil {
+				return err
+			}
+			out = append(out, t)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// FindBySecret implements challenge.Store.
+func (s *Store) FindBySecret(secret string) (*challenge.Token, error) {
+	var found *challenge.Token
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, data []byte) error {
+			if found != nil {
+				return nil
+			}
+			t := &challenge.Token{}
+			if err := json.Unmarshal(data, t); err != nil {
+				return err
+			}
+			if t.Secret == secret {
+				found = t
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, challenge.ErrNotFound
+	}
+	return found, nil
+}
+
+// Redeem implements challenge.Store.
+func (s *Store) Redeem(id string, now time.Time) (bool, error) {
+	var ok bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		data := b.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		t := &challenge.Token{}
+		if err := json.Unmarshal(data, t); err != nil {
+			return err
+		}
+		if !t.Valid(now) {
+			return nil
+		}
+		t.RemainingUses--
+		ok = true
+		updated, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), updated)
+	})
+	return ok, err
+}
@@ -0,0 +1,191 @@
+// Package challenge implements scoped, single-use SCEP enrollment
+// tokens: a replacement for a single static challenge password that lets
+// an operator issue narrowly-scoped credentials and revoke them
+// individually.
+package challenge
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+)
+
+// MatchPolicy restricts which CSRs a Token may be redeemed against. A
+// zero-value field in any dimension means "no restriction" on that
+// dimension. Patterns are plain regexes, kept as strings rather than
+// compiled *regexp.Regexp so a Token is plain data that round-trips
+// through any Store (in particular, JSON-backed ones).
+type MatchPolicy struct {
+	// CommonNamePattern, if set, must match the CSR's CommonName.
+	CommonNamePattern string
+	// SANPatterns, if non-empty, require every one of the CSR's DNS
+	// SANs to match at least one of these patterns.
+	SANPatterns []string
+	// KeyAlgorithm, if set, restricts the CSR's public key algorithm.
+	KeyAlgorithm x509.PublicKeyAlgorithm
+	// MinKeyBits, if set, is the minimum key size the CSR's public key
+	// must meet.
+	MinKeyBits int
+}
+
+// Matches reports whether csr satisfies p.
+func (p MatchPolicy) Matches(csr *x509.CertificateRequest) bool {
+	if p.CommonNamePattern != "" {
+		ok, err := regexp.MatchString(p.CommonNamePattern, csr.Subject.CommonName)
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if len(p.SANPatterns) > 0 {
+		for _, san := range csr.DNSNames {
+			if !anyPatternMatches(p.SANPatterns, san) {
+				return false
+			}
+		}
+	}
+	if p.KeyAlgorithm != x509.UnknownPublicKeyAlgorithm && csr.PublicKeyAlgorithm != p.KeyAlgorithm {
+		return false
+	}
+	if p.MinKeyBits > 0 && keyBits(csr.PublicKey) < p.MinKeyBits {
+		return false
+	}
+	return true
+}
+
+func anyPatternMatches(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, err := regexp.MatchString(p, s); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func keyBits(pub interface{}) int {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	default:
+		return 0
+	}
+}
+
+// Token is a single enrollment credential.
+type Token struct {
+	ID            string
+	Secret        string
+	NotBefore     time.Time
+	NotAfter      time.Time
+	RemainingUses int
+	Policy        MatchPolicy
+}
+
+// Valid reports whether t may still be redeemed at now, ignoring policy.
+func (t *Token) Valid(now time.Time) bool {
+	if t.RemainingUses <= 0 {
+		return false
+	}
+	if !t.NotBefore.IsZero() && now.Before(t.NotBefore) {
+		return false
+	}
+	if !t.NotAfter.IsZero() && now.After(t.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// ErrNotFound is returned by Store methods when no token matches.
+var ErrNotFound = errors.New("challenge: token not found")
+
+// Store is pluggable storage for Tokens. Implementations must make
+// Redeem atomic: concurrent redemptions of the same token must never
+// both succeed once RemainingUses reaches 0.
+type Store interface {
+	Put(t *Token) error
+	Get(id string) (*Token, error)
+	Delete(id string) error
+	List() ([]*Token, error)
+	FindBySecret(secret string) (*Token, error)
+	// Redeem decrements the remaining-use count for id if the token is
+	// still valid at now, returning false (with no error) if it is
+	// exhausted, expired, or not found.
+	Redeem(id string, now time.Time) (bool, error)
+}
+
+// Jar is the high-level entry point used by ChallengeJarMiddleware and
+// the admin API: it adds CSR policy matching on top of a Store.
+type Jar struct {
+	store Store
+}
+
+// NewJar returns a Jar backed by store.
+func NewJar(store Store) *Jar {
+	return &Jar{store: store}
+}
+
+// Issue saves t, generating an ID and Secret if they are empty.
+func (j *Jar) Issue(t *Token) error {
+	if t.ID == "" {
+		id, err := randomHex(16)
+		if err != nil {
+			return err
+		}
+		t.ID = id
+	}
+	if t.Secret == "" {
+		secret, err := randomHex(24)
+		if err != nil {
+			return err
+		}
+		t.Secret = secret
+	}
+	return j.store.Put(t)
+}
+
+// Revoke deletes the token identified by id.
+func (j *Jar) Revoke(id string) error {
+	return j.store.Delete(id)
+}
+
+// List returns every token in the jar.
+func (j *Jar) List() ([]*Token, error) {
+	return j.store.List()
+}
+
+// Redeem looks up the token matching secret, checks it is still valid and
+// that csr satisfies its match policy, and atomically decrements its
+// remaining-use count. A false return with a nil error means the
+// presented secret should be treated as denied, not as an operational
+// failure.
+func (j *Jar) Redeem(secret string, csr *x509.CertificateRequest) (bool, error) {
+	tok, err := j.store.FindBySecret(secret)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if !tok.Valid(now) {
+		return false, nil
+	}
+	if !tok.Policy.Matches(csr) {
+		return false, nil
+	}
+	return j.store.Redeem(tok.ID, now)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
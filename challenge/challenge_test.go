@@ -0,0 +1,139 @@
+package challenge_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/micromdm/scep/v2/challenge"
+	"github.com/micromdm/scep/v2/challenge/memory"
+)
+
+func newCSR(t *testing.T, cn string, dnsNames []string, bits int) *x509.CertificateRequest {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatalf("creating CSR: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("parsing CSR: %v", err)
+	}
+	return csr
+}
+
+func TestMatchPolicyCommonNamePattern(t *testing.T) {
+	p := challenge.MatchPolicy{CommonNamePattern: `^device-\d+$`}
+	if !p.Matches(newCSR(t, "device-42", nil, 2048)) {
+		t.Errorf("expected device-42 to match")
+	}
+	if p.Matches(newCSR(t, "laptop-42", nil, 2048)) {
+		t.Errorf("expected laptop-42 not to match")
+	}
+}
+
+func TestMatchPolicySANPatterns(t *testing.T) {
+	p := challenge.MatchPolicy{SANPatterns: []string{`\.internal\.example\.com$`}}
+	if !p.Matches(newCSR(t, "", []string{"host1.internal.example.com"}, 2048)) {
+		t.Errorf("expected matching SAN to pass")
+	}
+	if p.Matches(newCSR(t, "", []string{"host1.internal.example.com", "evil.example.net"}, 2048)) {
+		t.Errorf("expected a single non-matching SAN to fail the whole CSR")
+	}
+}
+
+func TestMatchPolicyMinKeyBits(t *testing.T) {
+	p := challenge.MatchPolicy{MinKeyBits: 2048}
+	if !p.Matches(newCSR(t, "ok", nil, 2048)) {
+		t.Errorf("expected a 2048-bit key to satisfy MinKeyBits: 2048")
+	}
+	if p.Matches(newCSR(t, "weak", nil, 1024)) {
+		t.Errorf("expected a 1024-bit key to fail MinKeyBits: 2048")
+	}
+}
+
+func TestMatchPolicyKeyAlgorithm(t *testing.T) {
+	p := challenge.MatchPolicy{KeyAlgorithm: x509.RSA}
+	if !p.Matches(newCSR(t, "ok", nil, 2048)) {
+		t.Errorf("expected an RSA CSR to satisfy KeyAlgorithm: RSA")
+	}
+}
+
+func TestTokenValid(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name string
+		tok  challenge.Token
+		want bool
+	}{
+		{"exhausted", challenge.Token{RemainingUses: 0}, false},
+		{"not yet valid", challenge.Token{RemainingUses: 1, NotBefore: now.Add(time.Hour)}, false},
+		{"expired", challenge.Token{RemainingUses: 1, NotAfter: now.Add(-time.Hour)}, false},
+		{"valid", challenge.Token{RemainingUses: 1}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.tok.Valid(now); got != c.want {
+				t.Errorf("Valid() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestJarRedeemChecksPolicyAndUses(t *testing.T) {
+	store := memory.New()
+	j := challenge.NewJar(store)
+
+	tok := &challenge.Token{
+		RemainingUses: 1,
+		Policy:        challenge.MatchPolicy{CommonNamePattern: `^device-\d+$`},
+	}
+	if err := j.Issue(tok); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	ok, err := j.Redeem(tok.Secret, newCSR(t, "laptop-1", nil, 2048))
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if ok {
+		t.Fatalf("Redeem should have been denied by the match policy")
+	}
+
+	ok, err = j.Redeem(tok.Secret, newCSR(t, "device-1", nil, 2048))
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if !ok {
+		t.Fatalf("Redeem should have succeeded for a matching CSR")
+	}
+
+	ok, err = j.Redeem(tok.Secret, newCSR(t, "device-1", nil, 2048))
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if ok {
+		t.Fatalf("Redeem should have been denied once RemainingUses is exhausted")
+	}
+}
+
+func TestJarRedeemUnknownSecret(t *testing.T) {
+	j := challenge.NewJar(memory.New())
+	ok, err := j.Redeem("does-not-exist", newCSR(t, "device-1", nil, 2048))
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if ok {
+		t.Fatalf("Redeem of an unknown secret should not succeed")
+	}
+}
@@ -0,0 +1,171 @@
+package challenge
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves the token-jar admin API:
+//
+//	POST   /admin/challenges      issue a token
+//	GET    /admin/challenges      list tokens
+//	DELETE /admin/challenges/{id} revoke a token
+//
+// Every request must carry "Authorization: Bearer <adminToken>".
+func AdminHandler(jar *Jar, adminToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/challenges", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			handleIssue(jar, w, r)
+		case http.MethodGet:
+			handleList(jar, w)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/admin/challenges/", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, adminToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/admin/challenges/")
+		handleRevoke(jar, w, id)
+	})
+	return mux
+}
+
+func authorized(r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		return false
+	}
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	presented := strings.TrimPrefix(auth, prefix)
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(adminToken)) == 1
+}
+
+type issueRequest struct {
+	Secret            string    `json:"secret,omitempty"`
+	NotBefore         time.Time `json:"not_before,omitempty"`
+	NotAfter          time.Time `json:"not_after,omitempty"`
+	RemainingUses     int       `json:"remaining_uses,omitempty"`
+	CommonNamePattern string    `json:"common_name_pattern,omitempty"`
+	SANPatterns       []string  `json:"san_patterns,omitempty"`
+	KeyAlgorithm      string    `json:"key_algorithm,omitempty"`
+	MinKeyBits        int       `json:"min_key_bits,omitempty"`
+}
+
+type tokenResponse struct {
+	ID            string    `json:"id"`
+	Secret        string    `json:"secret"`
+	NotBefore     time.Time `json:"not_before,omitempty"`
+	NotAfter      time.Time `json:"not_after,omitempty"`
+	RemainingUses int       `json:"remaining_uses"`
+}
+
+func toTokenResponse(t *Token) tokenResponse {
+	return tokenResponse{
+		ID:            t.ID,
+		Secret:        t.Secret,
+		NotBefore:     t.NotBefore,
+		NotAfter:      t.NotAfter,
+		RemainingUses: t.RemainingUses,
+	}
+}
+
+func handleIssue(jar *Jar, w http.ResponseWriter, r *http.Request) {
+	var req issueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	keyAlg, err := parseKeyAlgorithm(req.KeyAlgorithm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	remaining := req.RemainingUses
+	if remaining == 0 {
+		remaining = 1
+	}
+	tok := &Token{
+		Secret:        req.Secret,
+		NotBefore:     req.NotBefore,
+		NotAfter:      req.NotAfter,
+		RemainingUses: remaining,
+		Policy: MatchPolicy{
+			CommonNamePattern: req.CommonNamePattern,
+			SANPatterns:       req.SANPatterns,
+			KeyAlgorithm:      keyAlg,
+			MinKeyBits:        req.MinKeyBits,
+		},
+	}
+	if err := jar.Issue(tok); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, toTokenResponse(tok))
+}
+
+func handleList(jar *Jar, w http.ResponseWriter) {
+	toks, err := jar.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := make([]tokenResponse, len(toks))
+	for i, t := range toks {
+		out[i] = toTokenResponse(t)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func handleRevoke(jar *Jar, w http.ResponseWriter, id string) {
+	if id == "" {
+		http.Error(w, "missing token id", http.StatusBadRequest)
+		return
+	}
+	if err := jar.Revoke(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func parseKeyAlgorithm(s string) (x509.PublicKeyAlgorithm, error) {
+	switch strings.ToUpper(s) {
+	case "":
+		return x509.UnknownPublicKeyAlgorithm, nil
+	case "RSA":
+		return x509.RSA, nil
+	case "ECDSA":
+		return x509.ECDSA, nil
+	case "ED25519":
+		return x509.Ed25519, nil
+	default:
+		return x509.UnknownPublicKeyAlgorithm, fmt.Errorf("challenge: unknown key algorithm %q", s)
+	}
+}
@@ -0,0 +1,87 @@
+// Package memory implements an in-memory challenge.Store. Tokens do not
+// survive a process restart; use challenge/boltdb for persistence.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/micromdm/scep/v2/challenge"
+)
+
+// Store is an in-memory, mutex-guarded challenge.Store.
+type Store struct {
+	mu     sync.Mutex
+	tokens map[string]*challenge.Token
+}
+
+// New returns an empty Store.
+func New() *Store {
+	return &Store{tokens: make(map[string]*challenge.Token)}
+}
+
+// Put implements challenge.Store.
+func (s *Store) Put(t *challenge.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *t
+	s.tokens[t.ID] = &cp
+	return nil
+}
+
+// Get implements challenge.Store.
+func (s *Store) Get(id string) (*challenge.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil, challenge.ErrNotFound
+	}
+	cp := *t
+	return &cp, nil
+}
+
+// Delete implements challenge.Store.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, id)
+	return nil
+}
+
+// List implements challenge.Store.
+func (s *Store) List() ([]*challenge.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*challenge.Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		cp := *t
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+// FindBySecret implements challenge.Store.
+func (s *Store) FindBySecret(secret string) (*challenge.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Secret == secret {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, challenge.ErrNotFound
+}
+
+// Redeem implements challenge.Store.
+func (s *Store) Redeem(id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || !t.Valid(now) {
+		return false, nil
+	}
+	t.RemainingUses--
+	return true, nil
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/scep/v2/challenge"
+)
+
+func TestPutGetFindDelete(t *testing.T) {
+	s := New()
+	tok := &challenge.Token{ID: "tok1", Secret: "sekret", RemainingUses: 1}
+	if err := s.Put(tok); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := s.Get("tok1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Secret != "sekret" {
+		t.Errorf("Get returned Secret %q, want %q", got.Secret, "sekret")
+	}
+
+	found, err := s.FindBySecret("sekret")
+	if err != nil {
+		t.Fatalf("FindBySecret: %v", err)
+	}
+	if found.ID != "tok1" {
+		t.Errorf("FindBySecret returned ID %q, want %q", found.ID, "tok1")
+	}
+
+	if err := s.Delete("tok1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get("tok1"); err != challenge.ErrNotFound {
+		t.Errorf("Get after Delete = %v, want challenge.ErrNotFound", err)
+	}
+}
+
+// TestRedeemAtomic issues a token with a single remaining use and fires
+// concurrent Redeem calls at it: exactly one must succeed.
+func TestRedeemAtomic(t *testing.T) {
+	s := New()
+	tok := &challenge.Token{ID: "tok1", RemainingUses: 1}
+	if err := s.Put(tok); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	results := make([]bool, n)
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := s.Redeem("tok1", now)
+			if err != nil {
+				t.Errorf("Redeem: %v", err)
+			}
+			results[i] = ok
+		}(i)
+	}
+	wg.Wait()
+
+	successes := 0
+	for _, ok := range results {
+		if ok {
+			successes++
+		}
+	}
+	if successes != 1 {
+		t.Errorf("got %d successful redemptions of a single-use token, want 1", successes)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build pkcs11
+
+package main
+
+// Registers the pkcs11 key manager backend; only built when the pkcs11
+// build tag is set, since it requires cgo and a PKCS#11 module at
+// link time.
+import _ "github.com/micromdm/scep/v2/kms/pkcs11"
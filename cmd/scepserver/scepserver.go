@@ -1,25 +1,39 @@
 package main
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/micromdm/scep/v2/challenge"
+	"github.com/micromdm/scep/v2/challenge/boltdb"
+	"github.com/micromdm/scep/v2/challenge/memory"
+	"github.com/micromdm/scep/v2/crl"
 	"github.com/micromdm/scep/v2/csrverifier"
 	executablecsrverifier "github.com/micromdm/scep/v2/csrverifier/executable"
+	webhookcsrverifier "github.com/micromdm/scep/v2/csrverifier/webhook"
 	scepdepot "github.com/micromdm/scep/v2/depot"
 	"github.com/micromdm/scep/v2/depot/file"
+	"github.com/micromdm/scep/v2/kms"
+	_ "github.com/micromdm/scep/v2/kms/softkey"
 	scepserver "github.com/micromdm/scep/v2/server"
+	"github.com/micromdm/scep/v2/signer/upstream"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -55,6 +69,22 @@ func main() {
 		flClAllowRenewal    = flag.String("allowrenew", envString("SCEP_CERT_RENEW", "14"), "do not allow renewal until n days before expiry, set to 0 to always allow")
 		flChallengePassword = flag.String("challenge", envString("SCEP_CHALLENGE_PASSWORD", ""), "enforce a challenge password")
 		flCSRVerifierExec   = flag.String("csrverifierexec", envString("SCEP_CSR_VERIFIER_EXEC", ""), "will be passed the CSRs for verification")
+		flCSRVerifierURL    = flag.String("csrverifierurl", envString("SCEP_CSR_VERIFIER_URL", ""), "URL of a webhook to POST CSRs to for verification")
+		flCSRVerifierAuth   = flag.String("csrverifier-auth-header", envString("SCEP_CSR_VERIFIER_AUTH_HEADER", ""), "Authorization header value sent with the CSR verifier webhook request")
+		flCSRVerifierTime   = flag.Duration("csrverifier-timeout", envDuration("SCEP_CSR_VERIFIER_TIMEOUT", 30*time.Second), "timeout for each CSR verifier webhook request attempt; every retry gets a fresh timeout")
+		flCSRVerifierCA     = flag.String("csrverifier-ca", envString("SCEP_CSR_VERIFIER_CA", ""), "PEM CA bundle to verify the CSR verifier webhook's TLS certificate against")
+		flCSRVerifierOpen   = flag.Bool("csrverifier-fail-open", envBool("SCEP_CSR_VERIFIER_FAIL_OPEN"), "allow a CSR through if the CSR verifier webhook cannot be reached, instead of denying it")
+		flTrustedProxies    = flag.String("trusted-proxies", envString("SCEP_TRUSTED_PROXIES", ""), "comma-separated CIDRs of reverse proxies allowed to set X-Forwarded-For; if unset, X-Forwarded-For is ignored and the source IP is always taken from the connection")
+		flChallengeDB       = flag.String("challenge-db", envString("SCEP_CHALLENGE_DB", ""), "path to a BoltDB file for scoped challenge tokens; defaults to in-memory storage if an admin token is configured without this flag")
+		flAdminToken        = flag.String("admin-token", envString("SCEP_ADMIN_TOKEN", ""), "bearer token guarding the challenge admin API (POST/GET/DELETE /admin/challenges); enables the scoped challenge-token jar")
+		flKMS               = flag.String("kms", envString("SCEP_KMS", ""), "key manager URI, e.g. softkey:, pkcs11:token=...;object=ca, awskms:///alias/scep-ca. defaults to softkey: against -depot/ca.key")
+		flKMSSigningKey     = flag.String("kms-signing-key", envString("SCEP_KMS_SIGNING_KEY", ""), "key manager URI for the CA signing key, if different from -kms")
+		flKMSDecryptionKey  = flag.String("kms-decryption-key", envString("SCEP_KMS_DECRYPTION_KEY", ""), "key manager URI for the SCEP envelope decryption key, if different from -kms")
+		flRAUpstream        = flag.String("ra-upstream", envString("SCEP_RA_UPSTREAM", ""), "run in RA mode, forwarding CSR signing to this upstream CA, e.g. scep://ca.example.com/scep, acme://ca.example.com/acme/directory, vault://vault.example.com/v1/pki/sign-verbatim/scep-ra")
+		flRAIntermediates   = flag.String("ra-intermediates", envString("SCEP_RA_INTERMEDIATES", ""), "PEM bundle of upstream intermediate certs to include in issued certReps, RA mode only")
+		flCRLValidity       = flag.Duration("crl-validity", envDuration("SCEP_CRL_VALIDITY", 7*24*time.Hour), "validity period of each generated CRL")
+		flCRLRegenInterval  = flag.Duration("crl-regen-interval", envDuration("SCEP_CRL_REGEN_INTERVAL", 24*time.Hour), "how often to regenerate the CRL")
+		flCRLCDPURL         = flag.String("crl-cdp-url", envString("SCEP_CRL_CDP_URL", ""), "CRL Distribution Point URL to stamp into issued certificates, e.g. http://scep.example.com/crl")
 		flDebug             = flag.Bool("debug", envBool("SCEP_LOG_DEBUG"), "enable debug logging")
 		flLogJSON           = flag.Bool("log-json", envBool("SCEP_LOG_JSON"), "output JSON logs")
 		flInitCA            = flag.Bool("init-ca", envBool("SCEP_INIT_CA"), "initialize CA if has no keys")
@@ -160,19 +190,63 @@ func main() {
 		lginfo.Log("err", err, "msg", "No valid number for client cert validity")
 		os.Exit(1)
 	}
-	var csrVerifier csrverifier.CSRVerifier
+	var csrVerifiers csrverifier.Chain
 	if *flCSRVerifierExec > "" {
 		executableCSRVerifier, err := executablecsrverifier.New(*flCSRVerifierExec, lginfo)
 		if err != nil {
 			lginfo.Log("err", err, "msg", "Could not instantiate CSR verifier")
 			os.Exit(1)
 		}
-		csrVerifier = executableCSRVerifier
+		csrVerifiers = append(csrVerifiers, executableCSRVerifier)
+	}
+	if *flCSRVerifierURL != "" {
+		webhookOpts := []webhookcsrverifier.Option{
+			webhookcsrverifier.WithTimeout(*flCSRVerifierTime),
+		}
+		if *flCSRVerifierAuth != "" {
+			webhookOpts = append(webhookOpts, webhookcsrverifier.WithAuthHeader(*flCSRVerifierAuth))
+		}
+		if *flCSRVerifierOpen {
+			webhookOpts = append(webhookOpts, webhookcsrverifier.WithFailOpen())
+		}
+		if *flCSRVerifierCA != "" {
+			caBundle, err := ioutil.ReadFile(*flCSRVerifierCA)
+			if err != nil {
+				lginfo.Log("err", err, "msg", "Could not read CSR verifier CA bundle")
+				os.Exit(1)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caBundle) {
+				lginfo.Log("err", "no certificates found", "msg", "Could not parse CSR verifier CA bundle")
+				os.Exit(1)
+			}
+			webhookOpts = append(webhookOpts, webhookcsrverifier.WithTLSConfig(&tls.Config{RootCAs: pool}))
+		}
+		csrVerifiers = append(csrVerifiers, webhookcsrverifier.New(*flCSRVerifierURL, webhookOpts...))
+	}
+	var csrVerifier csrverifier.CSRVerifier
+	if len(csrVerifiers) > 0 {
+		csrVerifier = csrVerifiers
 	}
 
+	var challengeJar *challenge.Jar
+	if *flChallengeDB != "" {
+		store, err := boltdb.New(*flChallengeDB)
+		if err != nil {
+			lginfo.Log("err", err, "msg", "could not open challenge token database")
+			os.Exit(1)
+		}
+		challengeJar = challenge.NewJar(store)
+	} else if *flAdminToken != "" {
+		challengeJar = challenge.NewJar(memory.New())
+	}
+
+	crlPath := filepath.Join(*flDepotPath, "ca.crl")
+	var crlGen *crl.Generator // nil in RA mode, where there is no local CA signer to sign CRLs
+
 	var svc scepserver.Service // scep service
 	{
-		crts, key, err := depot.CA([]byte(*flCAPass))
+		crts, err := depot.CA()
 		if err != nil {
 			lginfo.Log("err", err)
 			os.Exit(1)
@@ -181,22 +255,102 @@ func main() {
 			lginfo.Log("err", "missing CA certificate")
 			os.Exit(1)
 		}
-		signerOpts := []scepdepot.Option{
-			scepdepot.WithAllowRenewalDays(allowRenewal),
-			scepdepot.WithValidityDays(clientValidity),
-			scepdepot.WithCAPass(*flCAPass),
+
+		kmsURI := *flKMS
+		if kmsURI == "" {
+			kmsURI = fmt.Sprintf("softkey:path=%s;pass=%s", filepath.Join(*flDepotPath, "ca.key"), *flCAPass)
+		}
+		signingKeyURI := *flKMSSigningKey
+		if signingKeyURI == "" {
+			signingKeyURI = kmsURI
+		}
+		decryptionKeyURI := *flKMSDecryptionKey
+		if decryptionKeyURI == "" {
+			decryptionKeyURI = kmsURI
+		}
+
+		keyManager, err := kms.New(kmsURI)
+		if err != nil {
+			lginfo.Log("err", err, "msg", "could not construct key manager")
+			os.Exit(1)
+		}
+		// In RA mode the CA signing key lives upstream: this server only
+		// needs its own RA key pair, to open the inbound SCEP envelope and
+		// to sign its replies.
+		caDecrypter, err := keyManager.Decrypter(decryptionKeyURI)
+		if err != nil {
+			lginfo.Log("err", err, "msg", "could not load CA decryption key")
+			os.Exit(1)
 		}
-		if *flSignServerAttrs {
-			signerOpts = append(signerOpts, scepdepot.WithSeverAttrs())
+
+		var signer scepserver.CSRSigner
+		var intermediates []*x509.Certificate
+		var replySigner crypto.Signer
+		if *flRAUpstream != "" {
+			raSigner, err := keyManager.Signer(signingKeyURI)
+			if err != nil {
+				lginfo.Log("err", err, "msg", "could not load RA signing key")
+				os.Exit(1)
+			}
+			replySigner = raSigner
+			signer, err = upstream.New(*flRAUpstream, upstream.Identity{
+				Cert:      crts[0],
+				Signer:    raSigner,
+				Decrypter: caDecrypter,
+			})
+			if err != nil {
+				lginfo.Log("err", err, "msg", "could not construct RA upstream signer")
+				os.Exit(1)
+			}
+			if *flRAIntermediates != "" {
+				intermediates, err = loadCertBundle(*flRAIntermediates)
+				if err != nil {
+					lginfo.Log("err", err, "msg", "could not load RA intermediate bundle")
+					os.Exit(1)
+				}
+			}
+		} else {
+			caSigner, err := keyManager.Signer(signingKeyURI)
+			if err != nil {
+				lginfo.Log("err", err, "msg", "could not load CA signing key")
+				os.Exit(1)
+			}
+			replySigner = caSigner
+			signerOpts := []scepdepot.Option{
+				scepdepot.WithAllowRenewalDays(allowRenewal),
+				scepdepot.WithValidityDays(clientValidity),
+			}
+			if *flSignServerAttrs {
+				signerOpts = append(signerOpts, scepdepot.WithServerAttrs())
+			}
+			if *flCRLCDPURL != "" {
+				signerOpts = append(signerOpts, scepdepot.WithCRLDistributionPoints(*flCRLCDPURL))
+			}
+			signer = scepdepot.NewSigner(depot, caSigner, signerOpts...)
+
+			crlGen = crl.NewGenerator(depot, crts[0], caSigner,
+				crl.WithValidity(*flCRLValidity),
+				crl.WithPath(crlPath),
+			)
+			if _, err := crlGen.Generate(); err != nil {
+				lginfo.Log("err", err, "msg", "could not generate initial CRL")
+				os.Exit(1)
+			}
 		}
-		var signer scepserver.CSRSigner = scepdepot.NewSigner(depot, signerOpts...)
-		if *flChallengePassword != "" {
+		if challengeJar != nil {
+			signer = scepserver.ChallengeJarMiddleware(challengeJar, signer)
+		} else if *flChallengePassword != "" {
 			signer = scepserver.ChallengeMiddleware(*flChallengePassword, signer)
 		}
 		if csrVerifier != nil {
 			signer = csrverifier.Middleware(csrVerifier, signer)
 		}
-		svc, err = scepserver.NewService(crts[0], key, signer, scepserver.WithLogger(logger))
+		svc, err = scepserver.NewService(crts[0], caDecrypter, signer,
+			scepserver.WithLogger(logger),
+			scepserver.WithIntermediateCerts(intermediates),
+			scepserver.WithCRLSource(func() ([]byte, error) { return crl.WrapForGetCRL(crlPath) }),
+			scepserver.WithReplySigner(replySigner),
+		)
 		if err != nil {
 			lginfo.Log("err", err)
 			os.Exit(1)
@@ -204,12 +358,28 @@ func main() {
 		svc = scepserver.NewLoggingService(log.With(lginfo, "component", "scep_service"), svc)
 	}
 
+	if crlGen != nil {
+		go crlGen.RunPeriodic(context.Background(), *flCRLRegenInterval, func(err error) {
+			lginfo.Log("err", err, "msg", "could not regenerate CRL")
+		})
+	}
+
 	var h http.Handler // http handler
 	{
 		e := scepserver.MakeServerEndpoints(svc)
 		e.GetEndpoint = scepserver.EndpointLoggingMiddleware(lginfo)(e.GetEndpoint)
 		e.PostEndpoint = scepserver.EndpointLoggingMiddleware(lginfo)(e.PostEndpoint)
-		h = scepserver.MakeHTTPHandler(e, svc, log.With(lginfo, "component", "http"))
+		var handlerOpts []scepserver.HTTPHandlerOption
+		if *flTrustedProxies != "" {
+			handlerOpts = append(handlerOpts, scepserver.WithTrustedProxies(strings.Split(*flTrustedProxies, ",")...))
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/scep", scepserver.MakeHTTPHandler(e, svc, log.With(lginfo, "component", "http"), handlerOpts...))
+		mux.Handle("/crl", crl.Handler(crlPath))
+		if *flAdminToken != "" && challengeJar != nil {
+			mux.Handle("/admin/", challenge.AdminHandler(challengeJar, *flAdminToken))
+		}
+		h = mux
 	}
 
 	// start http server
@@ -239,6 +409,10 @@ func main() {
 }
 
 func caMain(cmd *flag.FlagSet, args []string) int {
+	if len(args) >= 1 && args[0] == "revoke" {
+		return caRevoke(flag.NewFlagSet("ca revoke", flag.ExitOnError), args[1:])
+	}
+
 	var (
 		flDepotPath  = cmd.String("depot", envString("SCEP_FILE_DEPOT", "depot"), "path to ca folder")
 		flInit       = cmd.Bool("init-ca", envBool("SCEP_INIT_CA"), "create a new CA")
@@ -299,6 +473,68 @@ func caMain(cmd *flag.FlagSet, args []string) int {
 	return 0
 }
 
+// caRevoke implements `scep ca revoke -serial ...`: it marks serial
+// revoked in the depot and forces an immediate CRL regeneration so the
+// revocation takes effect without waiting for the next periodic cycle.
+func caRevoke(cmd *flag.FlagSet, args []string) int {
+	var (
+		flDepotPath = cmd.String("depot", envString("SCEP_FILE_DEPOT", "depot"), "path to ca folder")
+		flCAPass    = cmd.String("capass", envString("SCEP_CA_PASS", ""), "passwd for the ca.key")
+		flSerial    = cmd.String("serial", "", "serial number (hex) of the certificate to revoke")
+		flReason    = cmd.Int("reason", scepdepot.ReasonUnspecified, "CRL revocation reason code")
+		flCRLValid  = cmd.Duration("crl-validity", 7*24*time.Hour, "validity period of the regenerated CRL")
+	)
+	cmd.Parse(args)
+
+	if *flSerial == "" {
+		fmt.Fprintln(os.Stderr, "scep ca revoke: -serial is required")
+		return 1
+	}
+	serial, ok := new(big.Int).SetString(*flSerial, 16)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "scep ca revoke: invalid -serial %q\n", *flSerial)
+		return 1
+	}
+
+	d, err := file.NewFileDepot(*flDepotPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := d.Revoke(serial, *flReason); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	caCerts, err := d.CA()
+	if err != nil || len(caCerts) < 1 {
+		fmt.Fprintln(os.Stderr, "scep ca revoke: could not load CA certificate to regenerate CRL")
+		return 1
+	}
+	keyManager, err := kms.New(fmt.Sprintf("softkey:path=%s;pass=%s", filepath.Join(*flDepotPath, "ca.key"), *flCAPass))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	caSigner, err := keyManager.Signer(fmt.Sprintf("softkey:path=%s;pass=%s", filepath.Join(*flDepotPath, "ca.key"), *flCAPass))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	gen := crl.NewGenerator(d, caCerts[0], caSigner,
+		crl.WithValidity(*flCRLValid),
+		crl.WithPath(filepath.Join(*flDepotPath, "ca.crl")),
+	)
+	if _, err := gen.Generate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("revoked %s and regenerated %s\n", *flSerial, filepath.Join(*flDepotPath, "ca.crl"))
+	return 0
+}
+
 func copyFileToDepot(sourceFile string, depotPath string, filename string) error {
 	// create depot folder if missing
 	if err := os.MkdirAll(depotPath, 0755); err != nil {
@@ -353,6 +589,29 @@ func createKey(bits int, password []byte, depot string) (*rsa.PrivateKey, error)
 	return key, nil
 }
 
+// loadCertBundle parses every PEM-encoded CERTIFICATE block in path, in
+// order, e.g. a chain of upstream intermediates for RA mode.
+func loadCertBundle(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, crt)
+	}
+	return certs, nil
+}
+
 func storeFileInDepot(depot string, filename string, data []byte) error {
 	// create depot folder if missing
 	if err := os.MkdirAll(depot, 0755); err != nil {
@@ -424,6 +683,18 @@ func envInt(key string, def int) int {
 	return def
 }
 
+func envDuration(key string, def time.Duration) time.Duration {
+	if env := os.Getenv(key); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return d
+	}
+	return def
+}
+
 func envBool(key string) bool {
 	if env := os.Getenv(key); env == "true" {
 		return true
@@ -0,0 +1,135 @@
+// Package crl generates, persists, and serves a depot's certificate
+// revocation list.
+package crl
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/micromdm/scep/v2/depot"
+	"github.com/micromdm/scep/v2/kms"
+)
+
+// Generator builds a signed x509.RevocationList from a depot's revoked
+// certificates and persists it to disk.
+type Generator struct {
+	depot  depot.Depot
+	caCert *x509.Certificate
+	signer kms.Signer
+
+	validity time.Duration
+	path     string
+}
+
+// Option configures a Generator.
+type Option func(*Generator)
+
+// WithValidity sets how long an issued CRL is valid for before it must be
+// regenerated.
+func WithValidity(d time.Duration) Option {
+	return func(g *Generator) { g.validity = d }
+}
+
+// WithPath sets where the PEM-encoded CRL is written, e.g. depot/ca.crl.
+func WithPath(path string) Option {
+	return func(g *Generator) { g.path = path }
+}
+
+// NewGenerator returns a Generator that signs CRLs as caCert/signer,
+// sourcing revocations from d.
+func NewGenerator(d depot.Depot, caCert *x509.Certificate, signer kms.Signer, opts ...Option) *Generator {
+	g := &Generator{
+		depot:    d,
+		caCert:   caCert,
+		signer:   signer,
+		validity: 7 * 24 * time.Hour,
+		path:     "ca.crl",
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Generate builds a fresh CRL from the depot's current revocation list,
+// persists it, and returns the DER encoding.
+func (g *Generator) Generate() ([]byte, error) {
+	revoked, err := g.depot.ListRevoked()
+	if err != nil {
+		return nil, fmt.Errorf("crl: listing revoked certs: %w", err)
+	}
+
+	entries := make([]x509.RevocationListEntry, len(revoked))
+	for i, r := range revoked {
+		entries[i] = x509.RevocationListEntry{
+			SerialNumber:   r.Serial,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     r.Reason,
+		}
+	}
+
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(now.Unix()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(g.validity),
+		RevokedCertificateEntries: entries,
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, g.caCert, g.signer)
+	if err != nil {
+		return nil, fmt.Errorf("crl: creating revocation list: %w", err)
+	}
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if err := writeAtomic(g.path, pemBytes); err != nil {
+		return nil, fmt.Errorf("crl: writing %s: %w", g.path, err)
+	}
+	return der, nil
+}
+
+// RunPeriodic regenerates the CRL every interval until ctx is cancelled,
+// reporting generation errors to onErr if it is non-nil.
+func (g *Generator) RunPeriodic(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := g.Generate(); err != nil && onErr != nil {
+			onErr(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeAtomic replaces path's contents via a temp file and rename, so
+// concurrent readers never observe a truncated file.
+func writeAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
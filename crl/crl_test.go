@@ -0,0 +1,122 @@
+package crl
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/micromdm/scep/v2/depot"
+)
+
+// memDepot is a minimal in-memory depot.Depot exercising only the
+// ListRevoked bookkeeping Generate depends on.
+type memDepot struct {
+	revoked []depot.RevokedCert
+}
+
+func (d *memDepot) CA() ([]*x509.Certificate, error)           { return nil, nil }
+func (d *memDepot) Put(cn string, crt *x509.Certificate) error { return nil }
+func (d *memDepot) Serial() (*big.Int, error)                  { return nil, nil }
+func (d *memDepot) HasCN(cn string, allowTime int, cert *x509.Certificate, revokeOldCertificate bool) (bool, error) {
+	return false, nil
+}
+func (d *memDepot) Revoke(serial *big.Int, reason int) error { return nil }
+func (d *memDepot) IsRevoked(serial *big.Int) (bool, error)  { return false, nil }
+func (d *memDepot) ListRevoked() ([]depot.RevokedCert, error) {
+	return d.revoked, nil
+}
+
+func newTestCACert(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA cert: %v", err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA cert: %v", err)
+	}
+	return crt, key
+}
+
+func TestGenerateIncludesRevokedCerts(t *testing.T) {
+	caCert, caKey := newTestCACert(t)
+	d := &memDepot{revoked: []depot.RevokedCert{
+		{Serial: big.NewInt(42), RevokedAt: time.Now(), Reason: 1},
+	}}
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	gen := NewGenerator(d, caCert, caKey, WithPath(path))
+
+	der, err := gen.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	list, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatalf("ParseRevocationList: %v", err)
+	}
+	if len(list.RevokedCertificateEntries) != 1 {
+		t.Fatalf("got %d revoked entries, want 1", len(list.RevokedCertificateEntries))
+	}
+	if list.RevokedCertificateEntries[0].SerialNumber.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("revoked serial = %s, want 42", list.RevokedCertificateEntries[0].SerialNumber)
+	}
+
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "X509 CRL" {
+		t.Fatalf("ca.crl was not written as a PEM-encoded X509 CRL")
+	}
+}
+
+func TestGenerateOverwritesPreviousCRL(t *testing.T) {
+	caCert, caKey := newTestCACert(t)
+	d := &memDepot{}
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	gen := NewGenerator(d, caCert, caKey, WithPath(path))
+
+	if _, err := gen.Generate(); err != nil {
+		t.Fatalf("first Generate: %v", err)
+	}
+	d.revoked = []depot.RevokedCert{{Serial: big.NewInt(7), RevokedAt: time.Now()}}
+	if _, err := gen.Generate(); err != nil {
+		t.Fatalf("second Generate: %v", err)
+	}
+
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	list, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseRevocationList: %v", err)
+	}
+	if len(list.RevokedCertificateEntries) != 1 || list.RevokedCertificateEntries[0].SerialNumber.Cmp(big.NewInt(7)) != 0 {
+		t.Fatalf("second Generate did not replace the previous CRL contents: %+v", list.RevokedCertificateEntries)
+	}
+}
@@ -0,0 +1,45 @@
+package crl
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/micromdm/scep/v2/scep"
+)
+
+// Handler serves the depot's current CRL at a plain endpoint (e.g. /crl)
+// for CDP consumers, in raw DER form.
+func Handler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		der, err := readDER(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(der)
+	})
+}
+
+// WrapForGetCRL wraps the depot's current CRL in the degenerate SignedData
+// a SCEP GetCRL response carries (RFC 8894 §3.3.2).
+func WrapForGetCRL(path string) ([]byte, error) {
+	der, err := readDER(path)
+	if err != nil {
+		return nil, err
+	}
+	return scep.DegenerateSignedData(nil, [][]byte{der})
+}
+
+func readDER(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return data, nil
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,57 @@
+// Package csrverifier defines a pluggable check that runs against an
+// inbound CSR before the SCEP server signs it.
+package csrverifier
+
+import (
+	"context"
+	"crypto/x509"
+
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+// CSRVerifier inspects a raw, DER-encoded CSR and decides whether it may
+// be signed. ctx carries request-scoped values such as the source IP (see
+// scepserver.SourceIPFromContext).
+type CSRVerifier interface {
+	Verify(ctx context.Context, data []byte) (bool, error)
+}
+
+// Middleware wraps next so that a CSR must pass verifier before it is
+// signed.
+func Middleware(verifier CSRVerifier, next scepserver.CSRSigner) scepserver.CSRSigner {
+	return scepserver.CSRSignerFunc(func(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+		ok, err := verifier.Verify(ctx, csr.Raw)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errCSRNotAllowed
+		}
+		return next.SignCSR(ctx, csr)
+	})
+}
+
+// Chain runs multiple CSRVerifiers in order, requiring every one of them
+// to allow the CSR. It lets operators combine, e.g., the executable and
+// webhook verifiers and require both to pass.
+type Chain []CSRVerifier
+
+// Verify implements CSRVerifier.
+func (c Chain) Verify(ctx context.Context, data []byte) (bool, error) {
+	for _, v := range c {
+		ok, err := v.Verify(ctx, data)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+var errCSRNotAllowed = verifyError("csrverifier: CSR rejected by verifier")
+
+type verifyError string
+
+func (e verifyError) Error() string { return string(e) }
@@ -0,0 +1,44 @@
+// Package executable implements a csrverifier.CSRVerifier that shells out
+// to an external program, passing it the DER-encoded CSR on stdin and
+// interpreting its exit code as the verdict.
+package executable
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Executable is a csrverifier.CSRVerifier backed by an external program.
+type Executable struct {
+	path   string
+	logger log.Logger
+}
+
+// New returns an Executable verifier that runs the program at path.
+func New(path string, logger log.Logger) (*Executable, error) {
+	if _, err := exec.LookPath(path); err != nil {
+		return nil, fmt.Errorf("executable csrverifier: %w", err)
+	}
+	return &Executable{path: path, logger: logger}, nil
+}
+
+// Verify runs the configured executable, passing data on stdin. Exit code
+// 0 means allow, any other exit code means deny.
+func (e *Executable) Verify(ctx context.Context, data []byte) (bool, error) {
+	cmd := exec.Command(e.path)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			e.logger.Log("msg", "CSR verification failed", "stderr", stderr.String())
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,186 @@
+// Package webhook implements a csrverifier.CSRVerifier that delegates the
+// allow/deny decision to an external HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/scep/v2/scep"
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+// Verifier is a csrverifier.CSRVerifier backed by an HTTP endpoint: it
+// POSTs the CSR and a decoded summary of it as JSON and expects
+// {"allow": bool, "reason": string} back.
+type Verifier struct {
+	url        string
+	client     *http.Client
+	authHeader string
+	hmacSecret []byte
+	failOpen   bool
+	maxRetries int
+}
+
+// Option configures a Verifier.
+type Option func(*Verifier)
+
+// WithAuthHeader sets a static Authorization header sent with every request.
+func WithAuthHeader(value string) Option {
+	return func(v *Verifier) { v.authHeader = value }
+}
+
+// WithTimeout bounds each webhook request attempt. Every retry gets a fresh
+// timeout, and the backoff between attempts is not counted against it.
+func WithTimeout(d time.Duration) Option {
+	return func(v *Verifier) { v.client.Timeout = d }
+}
+
+// WithTLSConfig overrides the transport's TLS config, e.g. to pin the
+// webhook's CA (RootCAs) or present a client certificate (Certificates)
+// for mTLS.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(v *Verifier) {
+		v.client.Transport.(*http.Transport).TLSClientConfig = cfg
+	}
+}
+
+// WithHMACSecret signs every request body with HMAC-SHA256 using secret,
+// carried in the X-Scep-Signature header as a hex digest.
+func WithHMACSecret(secret []byte) Option {
+	return func(v *Verifier) { v.hmacSecret = secret }
+}
+
+// WithFailOpen allows a CSR through when the webhook cannot be reached or
+// returns an error, instead of the default fail-closed behavior.
+func WithFailOpen() Option {
+	return func(v *Verifier) { v.failOpen = true }
+}
+
+// WithMaxRetries bounds how many times a request is retried after a 5xx
+// response, with exponential backoff between attempts.
+func WithMaxRetries(n int) Option {
+	return func(v *Verifier) { v.maxRetries = n }
+}
+
+// New returns a Verifier that POSTs to url.
+func New(url string, opts ...Option) *Verifier {
+	v := &Verifier{
+		url: url,
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{}},
+		},
+		maxRetries: 3,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+type verifyRequest struct {
+	CSR               []byte   `json:"csr"`
+	CommonName        string   `json:"common_name,omitempty"`
+	DNSNames          []string `json:"dns_names,omitempty"`
+	IPAddresses       []string `json:"ip_addresses,omitempty"`
+	ChallengePassword string   `json:"challenge_password,omitempty"`
+	SourceIP          string   `json:"source_ip,omitempty"`
+}
+
+type verifyResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Verify implements csrverifier.CSRVerifier.
+func (v *Verifier) Verify(ctx context.Context, data []byte) (bool, error) {
+	body, err := json.Marshal(newVerifyRequest(data, scepserver.SourceIPFromContext(ctx)))
+	if err != nil {
+		return v.onError(fmt.Errorf("webhook csrverifier: encoding request: %w", err))
+	}
+
+	var lastErr error
+	backoff := time.Second
+	for attempt := 0; attempt <= v.maxRetries; attempt++ {
+		allow, retryable, err := v.post(body)
+		if err == nil {
+			return allow, nil
+		}
+		lastErr = err
+		if !retryable || attempt == v.maxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return v.onError(fmt.Errorf("webhook csrverifier: %w", lastErr))
+}
+
+func (v *Verifier) onError(err error) (bool, error) {
+	if v.failOpen {
+		return true, nil
+	}
+	return false, err
+}
+
+// post makes one attempt and reports whether the error, if any, is worth
+// retrying (a 5xx or transport-level failure).
+func (v *Verifier) post(body []byte) (allow bool, retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return false, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.authHeader != "" {
+		req.Header.Set("Authorization", v.authHeader)
+	}
+	if len(v.hmacSecret) > 0 {
+		mac := hmac.New(sha256.New, v.hmacSecret)
+		mac.Write(body)
+		req.Header.Set("X-Scep-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return false, true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	var out verifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, false, fmt.Errorf("decoding webhook response: %w", err)
+	}
+	return out.Allow, false, nil
+}
+
+func newVerifyRequest(data []byte, sourceIP string) verifyRequest {
+	req := verifyRequest{CSR: data, SourceIP: sourceIP}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return req
+	}
+	req.CommonName = csr.Subject.CommonName
+	req.DNSNames = csr.DNSNames
+	for _, ip := range csr.IPAddresses {
+		req.IPAddresses = append(req.IPAddresses, ip.String())
+	}
+	req.ChallengePassword = scep.ChallengePassword(csr)
+	return req
+}
@@ -0,0 +1,132 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+func TestVerifyAllow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(verifyResponse{Allow: true})
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL)
+	ok, err := v.Verify(context.Background(), []byte("csr-bytes"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !ok {
+		t.Fatalf("Verify = false, want true")
+	}
+}
+
+func TestVerifyThreadsSourceIP(t *testing.T) {
+	var got verifyRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+		json.NewEncoder(w).Encode(verifyResponse{Allow: true})
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL)
+	ctx := scepserver.WithSourceIP(context.Background(), "203.0.113.5")
+	if _, err := v.Verify(ctx, []byte("csr-bytes")); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.SourceIP != "203.0.113.5" {
+		t.Errorf("SourceIP = %q, want %q", got.SourceIP, "203.0.113.5")
+	}
+}
+
+func TestVerifyRetriesOn5xx(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(verifyResponse{Allow: true})
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL, WithMaxRetries(2))
+	ok, err := v.Verify(context.Background(), []byte("csr-bytes"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !ok {
+		t.Fatalf("Verify = false, want true")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestVerifyDoesNotRetryOn4xx(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL, WithMaxRetries(2))
+	if _, err := v.Verify(context.Background(), []byte("csr-bytes")); err == nil {
+		t.Fatalf("Verify should have failed on a 400 response")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1 (no retry on a non-retryable status)", attempts)
+	}
+}
+
+func TestVerifyFailOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL, WithFailOpen())
+	ok, err := v.Verify(context.Background(), []byte("csr-bytes"))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !ok {
+		t.Fatalf("Verify = false, want true (fail-open)")
+	}
+}
+
+func TestVerifyHMACSignature(t *testing.T) {
+	secret := []byte("shared-secret")
+	var gotSig string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSig = r.Header.Get("X-Scep-Signature")
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(verifyResponse{Allow: true})
+	}))
+	defer srv.Close()
+
+	v := New(srv.URL, WithHMACSecret(secret))
+	if _, err := v.Verify(context.Background(), []byte("csr-bytes")); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(gotBody)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if gotSig != want {
+		t.Errorf("X-Scep-Signature = %q, want %q", gotSig, want)
+	}
+}
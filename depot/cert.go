@@ -0,0 +1,99 @@
+package depot
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"time"
+)
+
+// CACert describes the identity of a self-signed Certificate Authority.
+type CACert struct {
+	years              int
+	commonName         string
+	organization       string
+	organizationalUnit string
+	country            string
+}
+
+// CAOption configures a CACert.
+type CAOption func(*CACert)
+
+// WithYears sets the validity period, in years, for the CA certificate.
+func WithYears(years int) CAOption {
+	return func(c *CACert) { c.years = years }
+}
+
+// WithCommonName sets the CA certificate's CN.
+func WithCommonName(cn string) CAOption {
+	return func(c *CACert) { c.commonName = cn }
+}
+
+// WithOrganization sets the CA certificate's O.
+func WithOrganization(o string) CAOption {
+	return func(c *CACert) { c.organization = o }
+}
+
+// WithOrganizationalUnit sets the CA certificate's OU.
+func WithOrganizationalUnit(ou string) CAOption {
+	return func(c *CACert) { c.organizationalUnit = ou }
+}
+
+// WithCountry sets the CA certificate's C.
+func WithCountry(country string) CAOption {
+	return func(c *CACert) { c.country = country }
+}
+
+// NewCACert builds a CACert template from the supplied options.
+func NewCACert(opts ...CAOption) *CACert {
+	c := &CACert{
+		years: 10,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SelfSign creates a self-signed CA certificate from the template and
+// returns the DER-encoded bytes.
+func (c *CACert) SelfSign(rand io.Reader, pub *rsa.PublicKey, priv *rsa.PrivateKey) ([]byte, error) {
+	subject := pkix.Name{
+		CommonName: c.commonName,
+	}
+	if c.organization != "" {
+		subject.Organization = []string{c.organization}
+	}
+	if c.organizationalUnit != "" {
+		subject.OrganizationalUnit = []string{c.organizationalUnit}
+	}
+	if c.country != "" {
+		subject.Country = []string{c.country}
+	}
+
+	serial, err := randSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(c.years, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	return x509.CreateCertificate(rand, template, template, pub, priv)
+}
+
+func randSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
@@ -0,0 +1,51 @@
+// Package depot defines storage for the Certificate Authority: the CA's
+// own identity plus the issued-certificate bookkeeping SCEP needs to
+// answer enrollment and renewal requests.
+package depot
+
+import (
+	"crypto/x509"
+	"math/big"
+	"time"
+)
+
+// RevokedCert describes one entry in the depot's revocation list.
+type RevokedCert struct {
+	Serial    *big.Int
+	RevokedAt time.Time
+	Reason    int // an RFC 5280 CRL reason code, e.g. ReasonKeyCompromise
+}
+
+// CRL reason codes, as defined by RFC 5280 §5.3.1. crypto/x509 does not
+// export these as constants, so the depot defines its own.
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonCertificateHold      = 6
+	ReasonRemoveFromCRL        = 8
+	ReasonPrivilegeWithdrawn   = 9
+	ReasonAACompromise         = 10
+)
+
+// Depot is a repository for managing X.509 certificates. It no longer
+// carries the CA's private key: signing and decryption now flow through a
+// kms.KeyManager, so a Depot is only ever asked for certificates and
+// bookkeeping.
+type Depot interface {
+	CA() ([]*x509.Certificate, error)
+	Put(cn string, crt *x509.Certificate) error
+	Serial() (*big.Int, error)
+	HasCN(cn string, allowTime int, cert *x509.Certificate, revokeOldCertificate bool) (bool, error)
+
+	// Revoke marks serial as revoked for the given reason (one of the
+	// Reason* constants above).
+	Revoke(serial *big.Int, reason int) error
+	// IsRevoked reports whether serial has been revoked.
+	IsRevoked(serial *big.Int) (bool, error)
+	// ListRevoked returns every revoked certificate, for CRL generation.
+	ListRevoked() ([]RevokedCert, error)
+}
@@ -0,0 +1,193 @@
+// Package file implements a depot.Depot backed by a directory on disk,
+// compatible with the layout OpenSSL's `ca` tool expects (ca.pem, ca.key,
+// index.txt, serial).
+package file
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	scepdepot "github.com/micromdm/scep/v2/depot"
+)
+
+// FileDepot is a depot.Depot backed by a directory on disk.
+type FileDepot struct {
+	path string
+
+	// serialMu guards the read-increment-persist of the serial file so
+	// concurrent Serial() calls never hand out the same number twice.
+	serialMu sync.Mutex
+}
+
+// NewFileDepot creates a FileDepot rooted at path. The directory must
+// already contain ca.pem; whether ca.key is present is not checked.
+func NewFileDepot(path string) (*FileDepot, error) {
+	if _, err := os.Stat(filepath.Join(path, "ca.pem")); err != nil {
+		return nil, err
+	}
+	return &FileDepot{path: path}, nil
+}
+
+// CA returns the CA certificate chain. The CA's private key is no longer
+// served from here: it is obtained from a kms.KeyManager instead, which
+// for file-based deployments reads the same ca.key via kms/softkey. In RA
+// mode ca.pem/ca.key hold the RA's own identity rather than a self-signed
+// CA, and CA simply returns that identity unchanged.
+func (d *FileDepot) CA() ([]*x509.Certificate, error) {
+	cert, err := d.getCACert()
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+func (d *FileDepot) getCACert() (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.path, "ca.pem"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("file depot: PEM decode failed for ca.pem")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Put stores crt in the depot, indexed by cn.
+func (d *FileDepot) Put(cn string, crt *x509.Certificate) error {
+	if crt == nil || crt.Raw == nil {
+		return errors.New("file depot: nil certificate")
+	}
+	name := fmt.Sprintf("%x.pem", crt.SerialNumber)
+	pemBlock := &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw}
+	if err := ioutil.WriteFile(filepath.Join(d.path, name), pem.EncodeToMemory(pemBlock), 0644); err != nil {
+		return err
+	}
+	return d.writeIndexEntry(indexEntry{
+		state:  indexStateValid,
+		cn:     cn,
+		serial: crt.SerialNumber,
+		expiry: crt.NotAfter,
+	})
+}
+
+// Serial returns the next certificate serial number to issue. Each call
+// atomically reads the current value from the serial file (creating it
+// with an initial value if it does not yet exist), persists the
+// incremented value, and returns the one just consumed, so no two calls
+// ever hand out the same serial.
+func (d *FileDepot) Serial() (*big.Int, error) {
+	d.serialMu.Lock()
+	defer d.serialMu.Unlock()
+
+	name := filepath.Join(d.path, "serial")
+	data, err := ioutil.ReadFile(name)
+
+	var current *big.Int
+	switch {
+	case os.IsNotExist(err):
+		current = big.NewInt(1)
+	case err != nil:
+		return nil, err
+	default:
+		var ok bool
+		current, ok = new(big.Int).SetString(strings.TrimSpace(string(data)), 16)
+		if !ok {
+			return nil, fmt.Errorf("file depot: invalid serial file contents")
+		}
+	}
+
+	next := new(big.Int).Add(current, big.NewInt(1))
+	if err := writeFileAtomic(d.path, name, []byte(fmt.Sprintf("%X\n", next))); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// HasCN reports whether cn already has a certificate on file, optionally
+// allowing renewal within allowTime days of expiry. An allowTime of 0
+// allows renewal at any time.
+func (d *FileDepot) HasCN(cn string, allowTime int, cert *x509.Certificate, revokeOldCertificate bool) (bool, error) {
+	entries, err := d.readIndex()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.cn != cn || e.state != indexStateValid {
+			continue
+		}
+		if allowTime == 0 || time.Until(e.expiry) <= time.Duration(allowTime)*24*time.Hour {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Revoke marks serial as revoked in index.txt, OpenSSL-style (state 'R',
+// plus a revocation timestamp and reason code).
+func (d *FileDepot) Revoke(serial *big.Int, reason int) error {
+	entries, err := d.readIndex()
+	if err != nil {
+		return err
+	}
+	found := false
+	for i, e := range entries {
+		if e.serial.Cmp(serial) != 0 {
+			continue
+		}
+		entries[i].state = indexStateRevoked
+		entries[i].revokedAt = time.Now()
+		entries[i].reason = reason
+		found = true
+		break
+	}
+	if !found {
+		return fmt.Errorf("file depot: no certificate with serial %x on file", serial)
+	}
+	return d.rewriteIndex(entries)
+}
+
+// IsRevoked reports whether serial has been revoked.
+func (d *FileDepot) IsRevoked(serial *big.Int) (bool, error) {
+	entries, err := d.readIndex()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.serial.Cmp(serial) == 0 {
+			return e.state == indexStateRevoked, nil
+		}
+	}
+	return false, nil
+}
+
+// ListRevoked returns every revoked certificate on file, for CRL
+// generation.
+func (d *FileDepot) ListRevoked() ([]scepdepot.RevokedCert, error) {
+	entries, err := d.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	var revoked []scepdepot.RevokedCert
+	for _, e := range entries {
+		if e.state != indexStateRevoked {
+			continue
+		}
+		revoked = append(revoked, scepdepot.RevokedCert{
+			Serial:    e.serial,
+			RevokedAt: e.revokedAt,
+			Reason:    e.reason,
+		})
+	}
+	return revoked, nil
+}
@@ -0,0 +1,134 @@
+package file
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestDepot(t *testing.T) *FileDepot {
+	t.Helper()
+	dir := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA cert: %v", err)
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, 0644); err != nil {
+		t.Fatalf("writing ca.pem: %v", err)
+	}
+
+	d, err := NewFileDepot(dir)
+	if err != nil {
+		t.Fatalf("NewFileDepot: %v", err)
+	}
+	return d
+}
+
+func newTestCert(t *testing.T, cn string, serial *big.Int) *x509.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(0, 0, 1),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating cert: %v", err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing cert: %v", err)
+	}
+	return crt
+}
+
+// TestSerialIncrements guards against the two-issuance collision bug: back
+// to back calls to Serial must never hand out the same number twice.
+func TestSerialIncrements(t *testing.T) {
+	d := newTestDepot(t)
+
+	first, err := d.Serial()
+	if err != nil {
+		t.Fatalf("Serial: %v", err)
+	}
+	second, err := d.Serial()
+	if err != nil {
+		t.Fatalf("Serial: %v", err)
+	}
+	if first.Cmp(second) == 0 {
+		t.Fatalf("Serial returned %s twice", first)
+	}
+	if second.Cmp(first) <= 0 {
+		t.Errorf("second serial %s is not greater than first %s", second, first)
+	}
+}
+
+func TestRevokeAndIsRevoked(t *testing.T) {
+	d := newTestDepot(t)
+
+	crt := newTestCert(t, "device-1", big.NewInt(42))
+	if err := d.Put("device-1", crt); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	revoked, err := d.IsRevoked(crt.SerialNumber)
+	if err != nil {
+		t.Fatalf("IsRevoked: %v", err)
+	}
+	if revoked {
+		t.Fatalf("freshly issued certificate reported as revoked")
+	}
+
+	if err := d.Revoke(crt.SerialNumber, 0); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	revoked, err = d.IsRevoked(crt.SerialNumber)
+	if err != nil {
+		t.Fatalf("IsRevoked: %v", err)
+	}
+	if !revoked {
+		t.Fatalf("revoked certificate not reported as revoked")
+	}
+
+	list, err := d.ListRevoked()
+	if err != nil {
+		t.Fatalf("ListRevoked: %v", err)
+	}
+	if len(list) != 1 || list[0].Serial.Cmp(crt.SerialNumber) != 0 {
+		t.Fatalf("ListRevoked = %+v, want one entry for serial %s", list, crt.SerialNumber)
+	}
+}
+
+func TestRevokeUnknownSerial(t *testing.T) {
+	d := newTestDepot(t)
+	if err := d.Revoke(big.NewInt(999), 0); err == nil {
+		t.Fatalf("Revoke of an unknown serial should fail")
+	}
+}
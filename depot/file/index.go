@@ -0,0 +1,157 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexState mirrors the single-character status column OpenSSL's `ca`
+// tool writes to index.txt.
+type indexState byte
+
+const (
+	indexStateValid   indexState = 'V'
+	indexStateRevoked indexState = 'R'
+)
+
+const indexTimeLayout = "060102150405Z"
+
+// indexEntry is one parsed row of index.txt.
+type indexEntry struct {
+	state     indexState
+	expiry    time.Time
+	revokedAt time.Time
+	reason    int
+	serial    *big.Int
+	cn        string
+}
+
+func (d *FileDepot) indexPath() string {
+	return filepath.Join(d.path, "index.txt")
+}
+
+func (d *FileDepot) readIndex() ([]indexEntry, error) {
+	f, err := os.Open(d.indexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, err := parseIndexLine(scanner.Text())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func parseIndexLine(line string) (indexEntry, error) {
+	var fields [6]string
+	// state, expiry, revocationInfo, serial, filename, subject
+	n := 0
+	start := 0
+	for i := 0; i < len(line) && n < 5; i++ {
+		if line[i] == '\t' {
+			fields[n] = line[start:i]
+			start = i + 1
+			n++
+		}
+	}
+	fields[n] = line[start:]
+
+	if fields[0] == "" {
+		return indexEntry{}, fmt.Errorf("file depot: empty index line")
+	}
+	expiry, _ := time.Parse(indexTimeLayout, fields[1])
+	serial, ok := new(big.Int).SetString(fields[3], 16)
+	if !ok {
+		return indexEntry{}, fmt.Errorf("file depot: bad serial in index.txt: %q", fields[3])
+	}
+
+	entry := indexEntry{
+		state:  indexState(fields[0][0]),
+		expiry: expiry,
+		serial: serial,
+		cn:     strings.TrimPrefix(fields[5], "/CN="),
+	}
+	if entry.state == indexStateRevoked {
+		entry.revokedAt, entry.reason = parseRevocationInfo(fields[2])
+	}
+	return entry, nil
+}
+
+// parseRevocationInfo parses OpenSSL's "revocationDate[,reasonCode]"
+// revocation-info column.
+func parseRevocationInfo(field string) (time.Time, int) {
+	parts := strings.SplitN(field, ",", 2)
+	revokedAt, _ := time.Parse(indexTimeLayout, parts[0])
+	reason := 0
+	if len(parts) == 2 {
+		reason, _ = strconv.Atoi(parts[1])
+	}
+	return revokedAt, reason
+}
+
+func formatIndexLine(e indexEntry) string {
+	revocationInfo := ""
+	if e.state == indexStateRevoked {
+		revocationInfo = fmt.Sprintf("%s,%d", e.revokedAt.UTC().Format(indexTimeLayout), e.reason)
+	}
+	return fmt.Sprintf("%c\t%s\t%s\t%02X\tunknown\t/CN=%s\n",
+		e.state, e.expiry.UTC().Format(indexTimeLayout), revocationInfo, e.serial, e.cn)
+}
+
+func (d *FileDepot) writeIndexEntry(e indexEntry) error {
+	f, err := os.OpenFile(d.indexPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(formatIndexLine(e))
+	return err
+}
+
+// rewriteIndex atomically replaces index.txt with entries, so a reader
+// never sees a partially-written file.
+func (d *FileDepot) rewriteIndex(entries []indexEntry) error {
+	var buf strings.Builder
+	for _, e := range entries {
+		buf.WriteString(formatIndexLine(e))
+	}
+	return writeFileAtomic(d.path, d.indexPath(), []byte(buf.String()))
+}
+
+// writeFileAtomic replaces path's contents via a temp file in dir plus a
+// rename, so a reader never observes a partially-written file.
+func writeFileAtomic(dir, path string, data []byte) error {
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
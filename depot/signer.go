@@ -0,0 +1,127 @@
+package depot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+
+	"github.com/micromdm/scep/v2/kms"
+)
+
+// Signer issues client certificates from CSRs by consulting a Depot for
+// the CA's identity and next serial number, and a kms.Signer to produce
+// the signature itself. The CA's private key material never has to be
+// in this process's memory: for a KMS/HSM-backed kms.Signer it never
+// leaves the backing hardware.
+type Signer struct {
+	depot    Depot
+	caSigner kms.Signer
+
+	allowRenewalDays int
+	validityDays     int
+	serverAttrs      bool
+	cdpURLs          []string
+}
+
+// Option configures a Signer.
+type Option func(*Signer)
+
+// WithAllowRenewalDays sets how many days before expiry a client may renew.
+func WithAllowRenewalDays(days int) Option {
+	return func(s *Signer) { s.allowRenewalDays = days }
+}
+
+// WithValidityDays sets the validity period, in days, of issued certificates.
+func WithValidityDays(days int) Option {
+	return func(s *Signer) { s.validityDays = days }
+}
+
+// WithServerAttrs marks issued certificates for server (not just client) use.
+func WithServerAttrs() Option {
+	return func(s *Signer) { s.serverAttrs = true }
+}
+
+// WithCRLDistributionPoints stamps issued certificates with a CRL
+// Distribution Points extension pointing at urls, e.g. the CDP URL the
+// crl package's GetCRL/CDP endpoints are served at.
+func WithCRLDistributionPoints(urls ...string) Option {
+	return func(s *Signer) { s.cdpURLs = urls }
+}
+
+// NewSigner returns a Signer backed by depot for certificate bookkeeping
+// and caSigner for the CA signature itself.
+func NewSigner(depot Depot, caSigner kms.Signer, opts ...Option) *Signer {
+	s := &Signer{
+		depot:            depot,
+		caSigner:         caSigner,
+		allowRenewalDays: 14,
+		validityDays:     365,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SignCSR signs csr using the CA identity held in the depot and returns the
+// issued certificate.
+func (s *Signer) SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	caCerts, err := s.depot.CA()
+	if err != nil {
+		return nil, err
+	}
+	if len(caCerts) < 1 {
+		return nil, fmt.Errorf("depot: missing CA certificate")
+	}
+	caCert := caCerts[0]
+
+	id, err := s.depot.HasCN(csr.Subject.CommonName, s.allowRenewalDays, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if id {
+		return nil, fmt.Errorf("depot: %q already has a valid certificate", csr.Subject.CommonName)
+	}
+
+	serial, err := s.depot.Serial()
+	if err != nil {
+		return nil, err
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	extKeyUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if s.serverAttrs {
+		extKeyUsage = append(extKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+
+	notBefore := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(0, 0, s.validityDays),
+		KeyUsage:     keyUsage,
+		ExtKeyUsage:  extKeyUsage,
+		DNSNames:     csr.DNSNames,
+		IPAddresses:  csr.IPAddresses,
+	}
+	if len(s.cdpURLs) > 0 {
+		template.CRLDistributionPoints = s.cdpURLs
+	}
+
+	crtBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, s.caSigner)
+	if err != nil {
+		return nil, err
+	}
+	crt, err := x509.ParseCertificate(crtBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.depot.Put(csr.Subject.CommonName, crt); err != nil {
+		return nil, err
+	}
+	return crt, nil
+}
@@ -0,0 +1,83 @@
+// Package kms abstracts the CA's signing and decryption keys behind a
+// URI-selected backend, so the key material for CSR signing and SCEP
+// envelope decryption need not live as a plaintext file next to the
+// server. Modelled on step-ca's kms package.
+package kms
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+)
+
+// Signer wraps a crypto.Signer used to sign issued client certificates.
+type Signer interface {
+	crypto.Signer
+}
+
+// Decrypter wraps a crypto.Decrypter used to unwrap the SCEP PKCS#7
+// envelope carrying the client's CSR.
+type Decrypter interface {
+	crypto.Decrypter
+}
+
+// KeyManager constructs Signers and Decrypters for keys it manages,
+// identified by backend-specific key URIs (e.g. a PKCS#11 object label,
+// a cloud KMS key alias).
+type KeyManager interface {
+	Signer(keyURI string) (Signer, error)
+	Decrypter(keyURI string) (Decrypter, error)
+	Close() error
+}
+
+// NewFunc constructs a KeyManager from a URI of the scheme it registered
+// for.
+type NewFunc func(uri string) (KeyManager, error)
+
+var registry = map[string]NewFunc{}
+
+// Register adds a KeyManager constructor for the given URI scheme (e.g.
+// "pkcs11", "awskms", "yubihsm", "softkey"). Backends call this from an
+// init function.
+func Register(scheme string, fn NewFunc) {
+	registry[scheme] = fn
+}
+
+// New constructs the KeyManager identified by uri's scheme, e.g.
+// "softkey:" or "pkcs11:token=...;object=ca".
+func New(uri string) (KeyManager, error) {
+	scheme, _ := Split(uri)
+	fn, ok := registry[scheme]
+	if !ok {
+		return nil, fmt.Errorf("kms: no key manager registered for scheme %q (uri %q)", scheme, uri)
+	}
+	return fn(uri)
+}
+
+// Split separates a key manager URI into its scheme and semicolon-
+// delimited parameter string, e.g. "pkcs11:token=foo;object=ca" splits
+// into ("pkcs11", "token=foo;object=ca").
+func Split(uri string) (scheme, params string) {
+	i := strings.Index(uri, ":")
+	if i < 0 {
+		return uri, ""
+	}
+	return uri[:i], uri[i+1:]
+}
+
+// ParseParams parses a semicolon-delimited "key=value" parameter string
+// as used by key manager URIs, e.g. "token=foo;object=ca".
+func ParseParams(params string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Split(params, ";") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out
+}
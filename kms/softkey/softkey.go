@@ -0,0 +1,76 @@
+// Package softkey is the default kms.KeyManager: it reads an unencrypted
+// or passphrase-encrypted RSA private key from disk, exactly as
+// depot/file has always done. It exists so that a "softkey:" URI is a
+// drop-in replacement for the hard-coded ca.key path, keeping current
+// file-depot deployments working unchanged once everything else moves
+// behind the kms.KeyManager abstraction.
+package softkey
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/micromdm/scep/v2/kms"
+)
+
+func init() {
+	kms.Register("softkey", New)
+}
+
+// KeyManager loads an RSA private key from a file on disk.
+type KeyManager struct {
+	path string
+	pass []byte
+}
+
+// New constructs a softkey.KeyManager from a URI of the form
+// "softkey:path=/path/to/ca.key;pass=secret". Both parameters are
+// optional; path defaults to "ca.key" relative to the server's working
+// directory.
+func New(uri string) (kms.KeyManager, error) {
+	_, rawParams := kms.Split(uri)
+	params := kms.ParseParams(rawParams)
+	path := params["path"]
+	if path == "" {
+		path = "ca.key"
+	}
+	return &KeyManager{path: path, pass: []byte(params["pass"])}, nil
+}
+
+// Signer returns the RSA private key at the configured path. keyURI is
+// unused: softkey manages exactly one key per KeyManager instance.
+func (m *KeyManager) Signer(keyURI string) (kms.Signer, error) {
+	return m.loadKey()
+}
+
+// Decrypter returns the RSA private key at the configured path. keyURI is
+// unused: softkey manages exactly one key per KeyManager instance.
+func (m *KeyManager) Decrypter(keyURI string) (kms.Decrypter, error) {
+	return m.loadKey()
+}
+
+// Close is a no-op: there is no handle to release for a file on disk.
+func (m *KeyManager) Close() error { return nil }
+
+func (m *KeyManager) loadKey() (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(m.path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("softkey: PEM decode failed")
+	}
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err = x509.DecryptPEMBlock(block, m.pass)
+		if err != nil {
+			return nil, fmt.Errorf("softkey: decrypting key: %w", err)
+		}
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
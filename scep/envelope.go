@@ -0,0 +1,434 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// These OIDs, together with oidSignedData/oidData from pkcs7.go, are the
+// PKCS#7/CMS (RFC 5652) and SCEP (RFC 8894 §3.2.1) identifiers needed to
+// build and parse a signed, encrypted PKIMessage. Only the RSA PKCS#1 v1.5
+// / AES-128-CBC / SHA-256 subset is implemented, which covers every SCEP
+// deployment this package otherwise supports (file/softkey and KMS-backed
+// RSA keys).
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidAES128CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidSCEPmessageType    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidSCEPpkiStatus      = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidSCEPfailInfo       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSCEPsenderNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidSCEPrecipientNonce = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidSCEPtransactionID  = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// attribute is a CMS Attribute (RFC 5652 §5.3): a SET OF exactly one value
+// in every message this package builds or expects.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+func newAttribute(oid asn1.ObjectIdentifier, valueDER []byte) attribute {
+	return attribute{
+		Type:   oid,
+		Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: valueDER},
+	}
+}
+
+func newOIDAttribute(oid, value asn1.ObjectIdentifier) (attribute, error) {
+	v, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+	return newAttribute(oid, v), nil
+}
+
+func newOctetStringAttribute(oid asn1.ObjectIdentifier, value []byte) (attribute, error) {
+	v, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+	return newAttribute(oid, v), nil
+}
+
+func newPrintableStringAttribute(oid asn1.ObjectIdentifier, value string) (attribute, error) {
+	v, err := asn1.MarshalWithParams(value, "printable")
+	if err != nil {
+		return attribute{}, err
+	}
+	return newAttribute(oid, v), nil
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm algorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm algorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           algorithmIdentifier
+	SignedAttrs               []attribute `asn1:"tag:0,set"`
+	DigestEncryptionAlgorithm algorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkiSignedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	ContentInfo      encapsulatedContentInfo
+	Certificates     []asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo    `asn1:"set"`
+}
+
+// newEnvelopedData encrypts content with a fresh AES-128-CBC key, wraps
+// that key for recipient's RSA public key (PKCS#1 v1.5 key transport, the
+// universal real-world SCEP convention), and returns the DER-encoded
+// EnvelopedData ContentInfo.
+func newEnvelopedData(recipient *x509.Certificate, content []byte) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("scep: unsupported recipient key type %T", recipient.PublicKey)
+	}
+
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("scep: generating content-encryption key: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, fmt.Errorf("scep: generating IV: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padded := pkcs7Pad(content, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	encKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+	if err != nil {
+		return nil, fmt.Errorf("scep: wrapping content-encryption key: %w", err)
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := envelopedData{
+		RecipientInfos: []recipientInfo{{
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: recipient.RawIssuer},
+				SerialNumber: recipient.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidAES128CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+			EncryptedContent:           ciphertext,
+		},
+	}
+	edBytes, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, err
+	}
+	ci := contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: edBytes},
+	}
+	return asn1.Marshal(ci)
+}
+
+// openEnvelopedData unwraps der's single RecipientInfo using dec and
+// decrypts its AES-128-CBC content.
+func openEnvelopedData(der []byte, dec crypto.Decrypter) ([]byte, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("scep: parsing ContentInfo: %w", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, fmt.Errorf("scep: expected envelopedData, got %v", ci.ContentType)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("scep: parsing EnvelopedData: %w", err)
+	}
+	if len(ed.RecipientInfos) != 1 {
+		return nil, fmt.Errorf("scep: expected exactly one RecipientInfo, got %d", len(ed.RecipientInfos))
+	}
+	ri := ed.RecipientInfos[0]
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		return nil, fmt.Errorf("scep: unsupported key-encryption algorithm %v", ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+	if !ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm.Equal(oidAES128CBC) {
+		return nil, fmt.Errorf("scep: unsupported content-encryption algorithm %v", ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+	}
+
+	key, err := dec.Decrypt(rand.Reader, ri.EncryptedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("scep: unwrapping content-encryption key: %w", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("scep: parsing content-encryption IV: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("scep: invalid content-encryption key: %w", err)
+	}
+	ciphertext := ed.EncryptedContentInfo.EncryptedContent
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 || len(iv) != aes.BlockSize {
+		return nil, errors.New("scep: malformed encrypted content")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return pkcs7Unpad(plaintext)
+}
+
+// newSignedData signs content (already the DER bytes of whatever
+// eContentType identifies, here always "data") as signerCert/signer,
+// attaching scepAttrs alongside the mandatory contentType/messageDigest
+// signed attributes, and returns the DER-encoded SignedData ContentInfo.
+func newSignedData(content []byte, signerCert *x509.Certificate, signer crypto.Signer, scepAttrs []attribute) ([]byte, error) {
+	contentTypeAttr, err := newOIDAttribute(oidContentType, oidData)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(content)
+	digestAttr, err := newOctetStringAttribute(oidMessageDigest, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signedAttrs := append([]attribute{contentTypeAttr, digestAttr}, scepAttrs...)
+
+	attrsForDigest, err := asn1.MarshalWithParams(signedAttrs, "set")
+	if err != nil {
+		return nil, fmt.Errorf("scep: encoding signed attributes: %w", err)
+	}
+	attrsDigest := sha256.Sum256(attrsForDigest)
+	sig, err := signer.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("scep: signing: %w", err)
+	}
+
+	si := signerInfo{
+		Version: 1,
+		IssuerAndSerialNumber: issuerAndSerialNumber{
+			Issuer:       asn1.RawValue{FullBytes: signerCert.RawIssuer},
+			SerialNumber: signerCert.SerialNumber,
+		},
+		DigestAlgorithm:           algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		SignedAttrs:               signedAttrs,
+		DigestEncryptionAlgorithm: algorithmIdentifier{Algorithm: oidSHA256WithRSA, Parameters: asn1.NullRawValue},
+		EncryptedDigest:           sig,
+	}
+	sd := pkiSignedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}},
+		ContentInfo:      encapsulatedContentInfo{EContentType: oidData, EContent: content},
+		Certificates:     []asn1.RawValue{{FullBytes: signerCert.Raw}},
+		SignerInfos:      []signerInfo{si},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	ci := contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes},
+	}
+	return asn1.Marshal(ci)
+}
+
+// parsedSignedData is the result of parsing and signature-verifying a
+// SignedData ContentInfo.
+type parsedSignedData struct {
+	Content []byte
+	Certs   []*x509.Certificate
+	Attrs   map[string][]byte // keyed by attribute OID string, DER of the one value in that attribute's SET
+}
+
+// parseSignedData parses der as a SignedData ContentInfo, verifies its
+// single SignerInfo's signature against the embedded signer certificate,
+// and verifies the messageDigest signed attribute matches the
+// encapsulated content.
+func parseSignedData(der []byte) (*parsedSignedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("scep: parsing ContentInfo: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("scep: expected signedData, got %v", ci.ContentType)
+	}
+	var sd pkiSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("scep: parsing SignedData: %w", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("scep: expected exactly one SignerInfo, got %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("scep: unsupported digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+	if !si.DigestEncryptionAlgorithm.Algorithm.Equal(oidSHA256WithRSA) {
+		return nil, fmt.Errorf("scep: unsupported signature algorithm %v", si.DigestEncryptionAlgorithm.Algorithm)
+	}
+
+	var certs []*x509.Certificate
+	for _, raw := range sd.Certificates {
+		crt, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("scep: parsing embedded certificate: %w", err)
+		}
+		certs = append(certs, crt)
+	}
+	signerCert, err := findSignerCert(certs, si.IssuerAndSerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := signerCert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("scep: unsupported signer key type %T", signerCert.PublicKey)
+	}
+
+	attrsForDigest, err := asn1.MarshalWithParams(si.SignedAttrs, "set")
+	if err != nil {
+		return nil, fmt.Errorf("scep: encoding signed attributes: %w", err)
+	}
+	attrsDigest := sha256.Sum256(attrsForDigest)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, attrsDigest[:], si.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("scep: signature verification failed: %w", err)
+	}
+
+	attrs := make(map[string][]byte, len(si.SignedAttrs))
+	for _, a := range si.SignedAttrs {
+		attrs[a.Type.String()] = a.Values.Bytes
+	}
+	var messageDigest []byte
+	if der, ok := attrs[oidMessageDigest.String()]; ok {
+		if _, err := asn1.Unmarshal(der, &messageDigest); err != nil {
+			return nil, fmt.Errorf("scep: decoding messageDigest attribute: %w", err)
+		}
+	}
+	contentDigest := sha256.Sum256(sd.ContentInfo.EContent)
+	if !bytes.Equal(messageDigest, contentDigest[:]) {
+		return nil, errors.New("scep: messageDigest attribute does not match encapsulated content")
+	}
+
+	return &parsedSignedData{
+		Content: sd.ContentInfo.EContent,
+		Certs:   certs,
+		Attrs:   attrs,
+	}, nil
+}
+
+func findSignerCert(certs []*x509.Certificate, ias issuerAndSerialNumber) (*x509.Certificate, error) {
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.SerialNumber) == 0 {
+			return c, nil
+		}
+	}
+	return nil, errors.New("scep: no certificate in SignedData matches its SignerInfo")
+}
+
+func attrString(attrs map[string][]byte, oid asn1.ObjectIdentifier) (string, error) {
+	der, ok := attrs[oid.String()]
+	if !ok {
+		return "", fmt.Errorf("scep: missing attribute %v", oid)
+	}
+	var s string
+	if _, err := asn1.Unmarshal(der, &s); err != nil {
+		return "", fmt.Errorf("scep: decoding attribute %v: %w", oid, err)
+	}
+	return s, nil
+}
+
+func attrBytes(attrs map[string][]byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	der, ok := attrs[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("scep: missing attribute %v", oid)
+	}
+	var b []byte
+	if _, err := asn1.Unmarshal(der, &b); err != nil {
+		return nil, fmt.Errorf("scep: decoding attribute %v: %w", oid, err)
+	}
+	return b, nil
+}
+
+func newNonce() ([]byte, error) {
+	n := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, n); err != nil {
+		return nil, fmt.Errorf("scep: generating nonce: %w", err)
+	}
+	return n, nil
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("scep: empty plaintext")
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, errors.New("scep: invalid PKCS#7 padding")
+	}
+	return data[:len(data)-padLen], nil
+}
@@ -0,0 +1,322 @@
+// Package scep implements the wire format of SCEP (RFC 8894): PKIMessage
+// envelopes, their PKCS#7 SignedData/EnvelopedData encoding, and the
+// request/reply message types exchanged between client and server.
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// MessageType is the SCEP pkiMessageType attribute.
+type MessageType string
+
+// SCEP pkiMessageType values, RFC 8894 §3.2.1.2.
+const (
+	CertRep        MessageType = "3"
+	PKCSReq        MessageType = "19"
+	GetCertInitial MessageType = "20"
+	GetCert        MessageType = "21"
+	GetCRL         MessageType = "22"
+)
+
+// PKIStatus is the SCEP pkiStatus attribute.
+type PKIStatus string
+
+// SCEP pkiStatus values, RFC 8894 §3.2.1.3.
+const (
+	Success PKIStatus = "0"
+	Failure PKIStatus = "2"
+	Pending PKIStatus = "3"
+)
+
+// PKIMessage represents a parsed SCEP request or reply, including the
+// PKCS#7 envelope carrying the client's certificate signing request.
+type PKIMessage struct {
+	TransactionID string
+	MessageType   MessageType
+	SenderNonce   []byte
+
+	// Recipients holds the certificate(s) carried in the outer SignedData,
+	// i.e. the sender's own identity: for a PKCSReq this is the client's
+	// self-signed certificate (RFC 8894 §2.3), used to encrypt the CertRep
+	// reply back to it.
+	Recipients []*x509.Certificate
+
+	// pkiEnvelope is the raw, still-encrypted PKCS#7 EnvelopedData payload.
+	pkiEnvelope []byte
+
+	// CSRReqMessage is populated by DecryptPKIEnvelope.
+	CSRReqMessage *CSRReqMessage
+}
+
+// CSRReqMessage is the decrypted body of a PKCSReq/RenewalReq PKIMessage.
+type CSRReqMessage struct {
+	RawDecrypted      []byte
+	CSR               *x509.CertificateRequest
+	ChallengePassword string
+}
+
+// CertRepMessage is a CertRep PKIMessage carrying the issued certificate
+// back to the client.
+type CertRepMessage struct {
+	PKIStatus      PKIStatus
+	RecipientNonce []byte
+	Certificate    *x509.Certificate
+
+	// IntermediateCerts are bundled alongside Certificate in the
+	// degenerate SignedData, e.g. the upstream CA chain in RA mode.
+	IntermediateCerts []*x509.Certificate
+
+	Degenerate []byte // degenerate PKCS#7 SignedData payload
+}
+
+// ParsePKIMessage parses a raw, DER-encoded SCEP PKIMessage: it verifies
+// the outer SignedData's signature against the certificate it carries and
+// recovers the SCEP attributes (transactionID, messageType, senderNonce).
+// The encapsulated PKCS#7 EnvelopedData is left encrypted; call
+// DecryptPKIEnvelope to open it.
+func ParsePKIMessage(data []byte) (*PKIMessage, error) {
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, fmt.Errorf("scep: parsing PKIMessage: %w", err)
+	}
+	transactionID, err := attrString(sd.Attrs, oidSCEPtransactionID)
+	if err != nil {
+		return nil, err
+	}
+	messageType, err := attrString(sd.Attrs, oidSCEPmessageType)
+	if err != nil {
+		return nil, err
+	}
+	senderNonce, err := attrBytes(sd.Attrs, oidSCEPsenderNonce)
+	if err != nil {
+		return nil, err
+	}
+	return &PKIMessage{
+		TransactionID: transactionID,
+		MessageType:   MessageType(messageType),
+		SenderNonce:   senderNonce,
+		Recipients:    sd.Certs,
+		pkiEnvelope:   sd.Content,
+	}, nil
+}
+
+// DecryptPKIEnvelope unwraps the PKCS#7 EnvelopedData in msg's pkiEnvelope
+// using dec, populating msg.CSRReqMessage. Previously this method used an
+// in-process *rsa.PrivateKey directly; it now delegates the RSA / AES
+// unwrap to whatever crypto.Decrypter the caller supplies, so a KMS- or
+// HSM-backed key never needs to leave its hardware boundary.
+func (msg *PKIMessage) DecryptPKIEnvelope(dec crypto.Decrypter) error {
+	if dec == nil {
+		return errors.New("scep: nil decrypter")
+	}
+	plaintext, err := openEnvelopedData(msg.pkiEnvelope, dec)
+	if err != nil {
+		return fmt.Errorf("scep: decrypting PKCS#7 envelope: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(plaintext)
+	if err != nil {
+		return fmt.Errorf("scep: parsing decrypted CSR: %w", err)
+	}
+	msg.CSRReqMessage = &CSRReqMessage{
+		RawDecrypted:      plaintext,
+		CSR:               csr,
+		ChallengePassword: ChallengePassword(csr),
+	}
+	return nil
+}
+
+// Encode builds the DER-encoded CertRep PKIMessage replying to req: on
+// Success it is signed by signerCert/signer (the server's own identity)
+// and, along with IntermediateCerts, encrypted to the client certificate
+// recovered from req.Recipients (RFC 8894 §2.3).
+func (rep *CertRepMessage) Encode(req *PKIMessage, signerCert *x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	if signer == nil || signerCert == nil {
+		return nil, errors.New("scep: nil reply signer")
+	}
+
+	var content []byte
+	if rep.PKIStatus == Success {
+		if rep.Certificate == nil {
+			return nil, errors.New("scep: success CertRep requires a certificate")
+		}
+		if len(req.Recipients) == 0 {
+			return nil, errors.New("scep: request carried no client certificate to encrypt the reply to")
+		}
+		certs := append([]*x509.Certificate{rep.Certificate}, rep.IntermediateCerts...)
+		degenerate, err := DegenerateSignedData(certs, nil)
+		if err != nil {
+			return nil, fmt.Errorf("scep: building degenerate SignedData: %w", err)
+		}
+		rep.Degenerate = degenerate
+		content, err = newEnvelopedData(req.Recipients[0], degenerate)
+		if err != nil {
+			return nil, fmt.Errorf("scep: encrypting reply: %w", err)
+		}
+	}
+
+	senderNonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := certRepAttributes(req.TransactionID, rep.PKIStatus, senderNonce, req.SenderNonce)
+	if err != nil {
+		return nil, err
+	}
+	return newSignedData(content, signerCert, signer, attrs)
+}
+
+func certRepAttributes(transactionID string, status PKIStatus, senderNonce, recipientNonce []byte) ([]attribute, error) {
+	txAttr, err := newPrintableStringAttribute(oidSCEPtransactionID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	typeAttr, err := newPrintableStringAttribute(oidSCEPmessageType, string(CertRep))
+	if err != nil {
+		return nil, err
+	}
+	statusAttr, err := newPrintableStringAttribute(oidSCEPpkiStatus, string(status))
+	if err != nil {
+		return nil, err
+	}
+	senderAttr, err := newOctetStringAttribute(oidSCEPsenderNonce, senderNonce)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []attribute{txAttr, typeAttr, statusAttr, senderAttr}
+	if len(recipientNonce) > 0 {
+		recipAttr, err := newOctetStringAttribute(oidSCEPrecipientNonce, recipientNonce)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, recipAttr)
+	}
+	return attrs, nil
+}
+
+// NewPKCSReq builds a PKCSReq PKIMessage enrolling csr: it encrypts csr to
+// caCert and signs the envelope as signerCert/signer, which per RFC 8894
+// §2.3 is typically a certificate the client generates and self-signs for
+// the duration of enrollment, so the server has something to encrypt its
+// CertRep reply back to. It returns the parsed message (for matching the
+// eventual CertRep's recipientNonce/transactionID) and its DER encoding.
+func NewPKCSReq(csr *x509.CertificateRequest, caCert *x509.Certificate, signerCert *x509.Certificate, signer crypto.Signer) (*PKIMessage, []byte, error) {
+	enveloped, err := newEnvelopedData(caCert, csr.Raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("scep: encrypting CSR: %w", err)
+	}
+
+	transactionID, err := newTransactionID()
+	if err != nil {
+		return nil, nil, err
+	}
+	senderNonce, err := newNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	txAttr, err := newPrintableStringAttribute(oidSCEPtransactionID, transactionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	typeAttr, err := newPrintableStringAttribute(oidSCEPmessageType, string(PKCSReq))
+	if err != nil {
+		return nil, nil, err
+	}
+	nonceAttr, err := newOctetStringAttribute(oidSCEPsenderNonce, senderNonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := newSignedData(enveloped, signerCert, signer, []attribute{txAttr, typeAttr, nonceAttr})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &PKIMessage{
+		TransactionID: transactionID,
+		MessageType:   PKCSReq,
+		SenderNonce:   senderNonce,
+	}, der, nil
+}
+
+// DecodeCertRep parses and verifies data as the CertRep PKIMessage replying
+// to req, decrypting the issued certificate (and any intermediates) with
+// dec when the reply reports Success.
+func DecodeCertRep(data []byte, req *PKIMessage, dec crypto.Decrypter) (*CertRepMessage, error) {
+	sd, err := parseSignedData(data)
+	if err != nil {
+		return nil, fmt.Errorf("scep: parsing CertRep: %w", err)
+	}
+	messageType, err := attrString(sd.Attrs, oidSCEPmessageType)
+	if err != nil {
+		return nil, err
+	}
+	if MessageType(messageType) != CertRep {
+		return nil, fmt.Errorf("scep: expected CertRep, got messageType %q", messageType)
+	}
+	status, err := attrString(sd.Attrs, oidSCEPpkiStatus)
+	if err != nil {
+		return nil, err
+	}
+	if recipientNonce, err := attrBytes(sd.Attrs, oidSCEPrecipientNonce); err == nil && req != nil {
+		if len(req.SenderNonce) > 0 && !bytes.Equal(recipientNonce, req.SenderNonce) {
+			return nil, errors.New("scep: recipientNonce does not match request senderNonce")
+		}
+	}
+
+	rep := &CertRepMessage{PKIStatus: PKIStatus(status)}
+	if rep.PKIStatus != Success {
+		return rep, nil
+	}
+	if dec == nil {
+		return nil, errors.New("scep: nil decrypter")
+	}
+	plaintext, err := openEnvelopedData(sd.Content, dec)
+	if err != nil {
+		return nil, fmt.Errorf("scep: decrypting CertRep: %w", err)
+	}
+	certs, err := ParseDegenerateSignedData(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("scep: parsing issued certificate: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("scep: CertRep carried no certificates")
+	}
+	rep.Certificate = certs[0]
+	rep.IntermediateCerts = certs[1:]
+	rep.Degenerate = plaintext
+	return rep, nil
+}
+
+func newTransactionID() (string, error) {
+	n, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(n), nil
+}
+
+var oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// ChallengePassword extracts the PKCS#9 challengePassword attribute from
+// csr, if present.
+func ChallengePassword(csr *x509.CertificateRequest) string {
+	for _, attr := range csr.Attributes {
+		if !attr.Type.Equal(oidChallengePassword) {
+			continue
+		}
+		for _, rdn := range attr.Value {
+			for _, atv := range rdn {
+				if s, ok := atv.Value.(string); ok {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
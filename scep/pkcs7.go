@@ -0,0 +1,81 @@
+package scep
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+)
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     []asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             []asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// DegenerateSignedData packages certs and DER-encoded crls into a
+// degenerate (signerless) PKCS#7 SignedData ContentInfo: the transport
+// envelope SCEP uses to carry certificates and CRLs that need no
+// signature of their own, such as GetCACert and GetCRL responses (RFC
+// 8894 §3.3.2).
+func DegenerateSignedData(certs []*x509.Certificate, crls [][]byte) ([]byte, error) {
+	sd := signedData{
+		Version:     1,
+		ContentInfo: contentInfo{ContentType: oidData},
+	}
+	for _, crt := range certs {
+		sd.Certificates = append(sd.Certificates, asn1.RawValue{FullBytes: crt.Raw})
+	}
+	for _, crl := range crls {
+		sd.CRLs = append(sd.CRLs, asn1.RawValue{FullBytes: crl})
+	}
+
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	ci := contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdBytes},
+	}
+	return asn1.Marshal(ci)
+}
+
+// ParseDegenerateSignedData parses a degenerate (signerless) PKCS#7
+// SignedData ContentInfo produced by DegenerateSignedData and returns the
+// certificates it carries.
+func ParseDegenerateSignedData(der []byte) ([]*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("scep: parsing ContentInfo: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("scep: expected signedData, got %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("scep: parsing SignedData: %w", err)
+	}
+	certs := make([]*x509.Certificate, 0, len(sd.Certificates))
+	for _, raw := range sd.Certificates {
+		crt, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("scep: parsing certificate: %w", err)
+		}
+		certs = append(certs, crt)
+	}
+	return certs, nil
+}
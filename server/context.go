@@ -0,0 +1,20 @@
+package scepserver
+
+import "context"
+
+type contextKey int
+
+const sourceIPKey contextKey = 0
+
+// WithSourceIP returns a copy of ctx carrying the client's source IP, as
+// set by the HTTP transport from the inbound request's remote address.
+func WithSourceIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, sourceIPKey, ip)
+}
+
+// SourceIPFromContext returns the source IP set by WithSourceIP, or "" if
+// none was set.
+func SourceIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(sourceIPKey).(string)
+	return ip
+}
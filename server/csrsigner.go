@@ -0,0 +1,23 @@
+package scepserver
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// CSRSigner issues a certificate for a CSR that has already been unwrapped
+// from its SCEP PKIMessage envelope. Implementations include the local
+// depot-backed signer and any verification/challenge middleware layered on
+// top of it. ctx carries request-scoped values such as the source IP (see
+// WithSourceIP) through to verifiers that need them.
+type CSRSigner interface {
+	SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error)
+}
+
+// CSRSignerFunc adapts a function to a CSRSigner.
+type CSRSignerFunc func(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error)
+
+// SignCSR implements CSRSigner.
+func (f CSRSignerFunc) SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	return f(ctx, csr)
+}
@@ -0,0 +1,85 @@
+package scepserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Endpoints collects the two go-kit endpoints the HTTP transport dispatches
+// to: GET for GetCACaps/GetCACert/GetCRL, POST for PKIOperation.
+type Endpoints struct {
+	GetEndpoint  Endpoint
+	PostEndpoint Endpoint
+}
+
+// Endpoint is a single request/response operation, go-kit style.
+type Endpoint func(ctx context.Context, request interface{}) (interface{}, error)
+
+// SCEPRequest is the input to both endpoints; Operation selects behavior
+// and Message carries the POST body (empty for GET operations).
+type SCEPRequest struct {
+	Operation string
+	Message   []byte
+}
+
+// SCEPResponse is the output of both endpoints.
+type SCEPResponse struct {
+	CACertNum int
+	Data      []byte
+	Err       error
+}
+
+// MakeServerEndpoints builds the Endpoints for svc.
+func MakeServerEndpoints(svc Service) Endpoints {
+	return Endpoints{
+		GetEndpoint:  makeGetEndpoint(svc),
+		PostEndpoint: makePostEndpoint(svc),
+	}
+}
+
+func makeGetEndpoint(svc Service) Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCEPRequest)
+		switch req.Operation {
+		case "GetCACaps":
+			data, err := svc.GetCACaps(ctx)
+			return SCEPResponse{Data: data, Err: err}, nil
+		case "GetCACert":
+			data, n, err := svc.GetCACert(ctx)
+			return SCEPResponse{Data: data, CACertNum: n, Err: err}, nil
+		case "GetCRL":
+			data, err := svc.GetCRL(ctx)
+			return SCEPResponse{Data: data, Err: err}, nil
+		default:
+			data, err := svc.PKIOperation(ctx, req.Message)
+			return SCEPResponse{Data: data, Err: err}, nil
+		}
+	}
+}
+
+func makePostEndpoint(svc Service) Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SCEPRequest)
+		data, err := svc.PKIOperation(ctx, req.Message)
+		return SCEPResponse{Data: data, Err: err}, nil
+	}
+}
+
+// EndpointLoggingMiddleware logs the duration and error of each endpoint
+// invocation.
+func EndpointLoggingMiddleware(logger log.Logger) func(Endpoint) Endpoint {
+	return func(next Endpoint) Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			begin := time.Now()
+			resp, err := next(ctx, request)
+			logErr := err
+			if r, ok := resp.(SCEPResponse); ok && logErr == nil {
+				logErr = r.Err
+			}
+			logger.Log("took", time.Since(begin), "err", logErr)
+			return resp, err
+		}
+	}
+}
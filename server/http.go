@@ -0,0 +1,108 @@
+package scepserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+)
+
+// HTTPHandlerOption configures MakeHTTPHandler.
+type HTTPHandlerOption func(*httpHandlerConfig)
+
+type httpHandlerConfig struct {
+	trustedProxies []*net.IPNet
+}
+
+// WithTrustedProxies makes MakeHTTPHandler honor X-Forwarded-For when (and
+// only when) the connecting peer's address falls within one of cidrs.
+// Without this option, X-Forwarded-For is ignored and the source IP is
+// always taken from the connection itself, since any client can set that
+// header to spoof its apparent address.
+func WithTrustedProxies(cidrs ...string) HTTPHandlerOption {
+	return func(c *httpHandlerConfig) {
+		for _, cidr := range cidrs {
+			if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+				c.trustedProxies = append(c.trustedProxies, ipnet)
+			}
+		}
+	}
+}
+
+// MakeHTTPHandler mounts the SCEP GET/POST endpoints at /scep.
+func MakeHTTPHandler(e Endpoints, svc Service, logger log.Logger, opts ...HTTPHandlerOption) http.Handler {
+	cfg := &httpHandlerConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scep", func(w http.ResponseWriter, r *http.Request) {
+		op := r.URL.Query().Get("operation")
+		ctx := WithSourceIP(context.Background(), sourceIP(r, cfg.trustedProxies))
+
+		var (
+			resp interface{}
+			err  error
+		)
+		switch r.Method {
+		case http.MethodGet:
+			resp, err = e.GetEndpoint(ctx, SCEPRequest{Operation: op})
+		case http.MethodPost:
+			body, rerr := ioutil.ReadAll(r.Body)
+			if rerr != nil {
+				http.Error(w, rerr.Error(), http.StatusBadRequest)
+				return
+			}
+			resp, err = e.PostEndpoint(ctx, SCEPRequest{Operation: op, Message: body})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			logger.Log("err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		scepResp := resp.(SCEPResponse)
+		if scepResp.Err != nil {
+			logger.Log("err", scepResp.Err)
+			http.Error(w, scepResp.Err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(scepResp.Data)
+	})
+	return mux
+}
+
+// sourceIP returns r's client address: the first hop of X-Forwarded-For
+// when it was set by a proxy in trustedProxies, otherwise RemoteAddr.
+// X-Forwarded-For is client-supplied and trivially spoofed, so it must
+// never be honored from an untrusted peer.
+func sourceIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" && isTrustedProxy(host, trustedProxies) {
+		return strings.TrimSpace(strings.SplitN(fwd, ",", 2)[0])
+	}
+	return host
+}
+
+func isTrustedProxy(host string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
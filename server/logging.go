@@ -0,0 +1,46 @@
+package scepserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type loggingService struct {
+	logger log.Logger
+	next   Service
+}
+
+// NewLoggingService wraps next with request logging.
+func NewLoggingService(logger log.Logger, next Service) Service {
+	return &loggingService{logger: logger, next: next}
+}
+
+func (mw *loggingService) GetCACaps(ctx context.Context) (caps []byte, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log("method", "GetCACaps", "err", err, "took", time.Since(begin))
+	}(time.Now())
+	return mw.next.GetCACaps(ctx)
+}
+
+func (mw *loggingService) GetCACert(ctx context.Context) (cert []byte, n int, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log("method", "GetCACert", "certs", n, "err", err, "took", time.Since(begin))
+	}(time.Now())
+	return mw.next.GetCACert(ctx)
+}
+
+func (mw *loggingService) PKIOperation(ctx context.Context, data []byte) (out []byte, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log("method", "PKIOperation", "err", err, "took", time.Since(begin))
+	}(time.Now())
+	return mw.next.PKIOperation(ctx, data)
+}
+
+func (mw *loggingService) GetCRL(ctx context.Context) (crl []byte, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log("method", "GetCRL", "err", err, "took", time.Since(begin))
+	}(time.Now())
+	return mw.next.GetCRL(ctx)
+}
@@ -0,0 +1,47 @@
+package scepserver
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/micromdm/scep/v2/challenge"
+	"github.com/micromdm/scep/v2/scep"
+)
+
+// ChallengeMiddleware wraps next so that a CSR is only signed if its
+// challengePassword attribute matches password. This is the legacy,
+// single static-password mode; see ChallengeJarMiddleware for scoped,
+// single-use tokens.
+func ChallengeMiddleware(password string, next CSRSigner) CSRSigner {
+	return CSRSignerFunc(func(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+		if password == "" {
+			return nil, fmt.Errorf("scepserver: challenge password required")
+		}
+		if scep.ChallengePassword(csr) != password {
+			return nil, fmt.Errorf("scepserver: challenge password mismatch")
+		}
+		return next.SignCSR(ctx, csr)
+	})
+}
+
+// ChallengeJarMiddleware wraps next so that a CSR is only signed if its
+// challengePassword attribute identifies a still-valid token in jar whose
+// match policy the CSR satisfies. On success the token's remaining-use
+// count is atomically decremented.
+func ChallengeJarMiddleware(jar *challenge.Jar, next CSRSigner) CSRSigner {
+	return CSRSignerFunc(func(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+		secret := scep.ChallengePassword(csr)
+		if secret == "" {
+			return nil, fmt.Errorf("scepserver: challenge password required")
+		}
+		ok, err := jar.Redeem(secret, csr)
+		if err != nil {
+			return nil, fmt.Errorf("scepserver: challenge jar: %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("scepserver: challenge token rejected")
+		}
+		return next.SignCSR(ctx, csr)
+	})
+}
@@ -0,0 +1,153 @@
+// Package scepserver implements the HTTP transport and business logic for
+// a SCEP (RFC 8894) server: GetCACaps, GetCACert and PKIOperation.
+package scepserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/micromdm/scep/v2/scep"
+)
+
+// Service is the SCEP server business logic, independent of transport.
+type Service interface {
+	// GetCACaps returns a list of SCEP options this server supports.
+	GetCACaps(ctx context.Context) ([]byte, error)
+
+	// GetCACert returns the CA certificate, or certificate chain, in its
+	// DER or PKCS#7 degenerate form.
+	GetCACert(ctx context.Context) ([]byte, int, error)
+
+	// PKIOperation handles a PKIMessage whose envelope carries a CSR
+	// (PKCSReq or RenewalReq) and returns the encoded CertRep. The message
+	// type is not checked, and GetCert and GetCRL PKIMessages are not
+	// handled.
+	PKIOperation(ctx context.Context, data []byte) ([]byte, error)
+
+	// GetCRL returns the depot's current CRL, wrapped in the degenerate
+	// SignedData a SCEP GetCRL response carries (RFC 8894 §3.3.2).
+	GetCRL(ctx context.Context) ([]byte, error)
+}
+
+type service struct {
+	CA            []byte // raw DER bytes
+	CAcerts       []*x509.Certificate
+	decrypter     crypto.Decrypter
+	signer        CSRSigner
+	intermediates []*x509.Certificate
+	crlSource     func() ([]byte, error)
+	replySigner   crypto.Signer
+
+	/// info logging is implemented in the service middleware
+	debugLogger log.Logger
+}
+
+// ServiceOption configures a Service.
+type ServiceOption func(*service) error
+
+// WithLogger configures a logger for the service.
+func WithLogger(logger log.Logger) ServiceOption {
+	return func(s *service) error {
+		s.debugLogger = logger
+		return nil
+	}
+}
+
+// WithIntermediateCerts bundles certs into every issued CertRep, e.g. the
+// upstream CA chain in RA mode.
+func WithIntermediateCerts(certs []*x509.Certificate) ServiceOption {
+	return func(s *service) error {
+		s.intermediates = certs
+		return nil
+	}
+}
+
+// WithCRLSource configures the GetCRL operation to serve whatever fn
+// returns, already wrapped in its degenerate SignedData envelope (see
+// crl.WrapForGetCRL). Without this option, GetCRL always fails.
+func WithCRLSource(fn func() ([]byte, error)) ServiceOption {
+	return func(s *service) error {
+		s.crlSource = fn
+		return nil
+	}
+}
+
+// WithReplySigner configures the key used to sign the outer SignedData of
+// every CertRep this service returns. Without this option, NewService
+// falls back to key if it also implements crypto.Signer (the common case:
+// a single RSA keypair both decrypts requests and signs replies).
+func WithReplySigner(signer crypto.Signer) ServiceOption {
+	return func(s *service) error {
+		s.replySigner = signer
+		return nil
+	}
+}
+
+// NewService creates a new scep service. crt and key identify the server's
+// own certificate; key must implement crypto.Decrypter so the PKCS#7
+// envelope on inbound requests can be opened, whether it is a plain
+// *rsa.PrivateKey or a KMS-backed signer/decrypter.
+func NewService(crt *x509.Certificate, key crypto.Decrypter, signer CSRSigner, opts ...ServiceOption) (Service, error) {
+	s := &service{
+		CA:        crt.Raw,
+		CAcerts:   []*x509.Certificate{crt},
+		decrypter: key,
+		signer:    signer,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.replySigner == nil {
+		if replySigner, ok := key.(crypto.Signer); ok {
+			s.replySigner = replySigner
+		}
+	}
+	return s, nil
+}
+
+func (svc *service) GetCACaps(ctx context.Context) ([]byte, error) {
+	caps := []byte("Renewal\nSHA-256\nAES\nPOSTPKIOperation\n")
+	return caps, nil
+}
+
+func (svc *service) GetCACert(ctx context.Context) ([]byte, int, error) {
+	if len(svc.CAcerts) == 0 {
+		return nil, 0, fmt.Errorf("scepserver: missing CA certificate")
+	}
+	return svc.CAcerts[0].Raw, len(svc.CAcerts), nil
+}
+
+func (svc *service) PKIOperation(ctx context.Context, data []byte) ([]byte, error) {
+	if svc.replySigner == nil {
+		return nil, fmt.Errorf("scepserver: no reply signer configured, see WithReplySigner")
+	}
+	msg, err := scep.ParsePKIMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.DecryptPKIEnvelope(svc.decrypter); err != nil {
+		return nil, err
+	}
+	crt, err := svc.signer.SignCSR(ctx, msg.CSRReqMessage.CSR)
+	if err != nil {
+		return nil, err
+	}
+	rep := &scep.CertRepMessage{
+		PKIStatus:         scep.Success,
+		Certificate:       crt,
+		IntermediateCerts: svc.intermediates,
+	}
+	return rep.Encode(msg, svc.CAcerts[0], svc.replySigner)
+}
+
+func (svc *service) GetCRL(ctx context.Context) ([]byte, error) {
+	if svc.crlSource == nil {
+		return nil, fmt.Errorf("scepserver: GetCRL not configured, see WithCRLSource")
+	}
+	return svc.crlSource()
+}
@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/micromdm/scep/v2/scep"
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+func init() {
+	Register("scep", newSCEPBackend)
+}
+
+// scepBackend forwards CSR signing to another SCEP server's PKIOperation
+// endpoint, the same way a client would, but acting on behalf of every
+// enrolling client this RA terminates SCEP for. It authenticates each
+// enrollment to the upstream as id (the RA's own identity), per RFC 8894
+// §2.3.
+type scepBackend struct {
+	endpoint string
+	client   *http.Client
+	id       Identity
+}
+
+func newSCEPBackend(uri string, id Identity) (scepserver.CSRSigner, error) {
+	endpoint := "https://" + strings.TrimPrefix(uri, "scep://")
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("upstream/scep: invalid endpoint %q: %w", uri, err)
+	}
+	if id.Cert == nil || id.Signer == nil || id.Decrypter == nil {
+		return nil, fmt.Errorf("upstream/scep: RA identity (certificate, signer and decrypter) is required")
+	}
+	return &scepBackend{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		id:       id,
+	}, nil
+}
+
+// SignCSR enrolls csr with the upstream SCEP server: it fetches the
+// upstream's CA certificate, builds a PKCSReq PKIMessage signed and
+// encrypted as b.id, POSTs it to PKIOperation, and decrypts the returned
+// CertRep.
+func (b *scepBackend) SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	caCert, err := b.getCACert(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("upstream/scep: fetching upstream CA certificate: %w", err)
+	}
+
+	req, reqDER, err := scep.NewPKCSReq(csr, caCert, b.id.Cert, b.id.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("upstream/scep: building PKCSReq: %w", err)
+	}
+
+	respDER, err := b.post(ctx, "PKIOperation", "application/x-pki-message", reqDER)
+	if err != nil {
+		return nil, fmt.Errorf("upstream/scep: PKIOperation: %w", err)
+	}
+
+	rep, err := scep.DecodeCertRep(respDER, req, b.id.Decrypter)
+	if err != nil {
+		return nil, fmt.Errorf("upstream/scep: decoding CertRep: %w", err)
+	}
+	if rep.PKIStatus != scep.Success {
+		return nil, fmt.Errorf("upstream/scep: upstream returned pkiStatus %s", rep.PKIStatus)
+	}
+	return rep.Certificate, nil
+}
+
+func (b *scepBackend) getCACert(ctx context.Context) (*x509.Certificate, error) {
+	body, err := b.get(ctx, "GetCACert")
+	if err != nil {
+		return nil, err
+	}
+	if crt, err := x509.ParseCertificate(body); err == nil {
+		return crt, nil
+	}
+	certs, err := scep.ParseDegenerateSignedData(body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates returned")
+	}
+	return certs[0], nil
+}
+
+func (b *scepBackend) get(ctx context.Context, operation string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.endpoint+"?operation="+operation, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %w", b.endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %s: %s", resp.Status, body)
+	}
+	return body, nil
+}
+
+func (b *scepBackend) post(ctx context.Context, operation, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint+"?operation="+operation, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %w", b.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %s: %s", resp.Status, respBody)
+	}
+	return respBody, nil
+}
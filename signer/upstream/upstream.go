@@ -0,0 +1,51 @@
+// Package upstream implements scepserver.CSRSigner backends that forward
+// CSR signing to an upstream CA instead of signing locally, for use in RA
+// (Registration Authority) mode: this server still terminates SCEP for
+// clients, but issuance is delegated to another SCEP endpoint, an ACME CA,
+// or a Vault PKI secrets engine.
+package upstream
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+// Identity is the RA's own certificate and key material, needed by
+// backends (such as scep) that must themselves authenticate a SCEP
+// enrollment to the upstream CA.
+type Identity struct {
+	Cert      *x509.Certificate
+	Signer    crypto.Signer
+	Decrypter crypto.Decrypter
+}
+
+// NewFunc constructs a backend CSRSigner from the URI it registered for,
+// e.g. "scep://ca.example.com/scep", and the RA's own identity.
+type NewFunc func(uri string, id Identity) (scepserver.CSRSigner, error)
+
+var registry = map[string]NewFunc{}
+
+// Register adds a backend constructor for the given URI scheme. Backends
+// call this from an init function.
+func Register(scheme string, fn NewFunc) {
+	registry[scheme] = fn
+}
+
+// New parses uri and returns the scepserver.CSRSigner for the matching
+// upstream backend, e.g. "scep://...", "acme://...", "vault://...". id is
+// the RA's own identity, passed through to backends that need it.
+func New(uri string, id Identity) (scepserver.CSRSigner, error) {
+	scheme, _, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("upstream: %q is not a valid upstream URI", uri)
+	}
+	fn, ok := registry[scheme]
+	if !ok {
+		return nil, fmt.Errorf("upstream: no backend registered for scheme %q", scheme)
+	}
+	return fn(uri, id)
+}
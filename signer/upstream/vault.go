@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	scepserver "github.com/micromdm/scep/v2/server"
+)
+
+func init() {
+	Register("vault", newVaultBackend)
+}
+
+// vaultBackend signs CSRs through a Vault PKI secrets engine's sign-verbatim
+// endpoint. The upstream URI is of the form
+// "vault://vault.example.com/v1/pki/sign-verbatim/scep-ra"; the token is
+// read from VAULT_TOKEN.
+type vaultBackend struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func newVaultBackend(uri string, id Identity) (scepserver.CSRSigner, error) {
+	endpoint := "https://" + strings.TrimPrefix(uri, "vault://")
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("upstream/vault: invalid endpoint %q: %w", uri, err)
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return nil, errors.New("upstream/vault: VAULT_TOKEN is not set")
+	}
+	return &vaultBackend{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type vaultSignRequest struct {
+	CSR string `json:"csr"`
+}
+
+type vaultSignResponse struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+}
+
+// SignCSR PEM-encodes csr and POSTs it to Vault's sign-verbatim endpoint.
+func (b *vaultBackend) SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})
+	body, err := json.Marshal(vaultSignRequest{CSR: string(csrPEM)})
+	if err != nil {
+		return nil, fmt.Errorf("upstream/vault: encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("upstream/vault: building request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", b.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream/vault: calling %s: %w", b.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream/vault: vault returned %s", resp.Status)
+	}
+
+	var signResp vaultSignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signResp); err != nil {
+		return nil, fmt.Errorf("upstream/vault: decoding response: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(signResp.Data.Certificate))
+	if block == nil {
+		return nil, errors.New("upstream/vault: PEM decode failed for issued certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}